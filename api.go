@@ -0,0 +1,708 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	apiDefaultLimit = 50
+	apiMaxLimit     = 500
+	apiMaxBodySize  = 64 * 1024
+)
+
+type APIUser struct {
+	Name          string `json:"name"`
+	CharacterName string `json:"character_name"`
+	Room          string `json:"room"`
+	IsGameMaster  bool   `json:"is_game_master"`
+}
+
+type APIDieResult struct {
+	Value        int  `json:"value"`
+	Crit         bool `json:"crit"`
+	Complication bool `json:"complication"`
+	Dropped      bool `json:"dropped"`
+	Rerolled     bool `json:"rerolled"`
+	Previous     int  `json:"previous,omitempty"`
+
+	Determination bool `json:"determination"`
+}
+
+type APIChallengeDieResult struct {
+	Face   int  `json:"face"`
+	Value  int  `json:"value"`
+	Effect bool `json:"effect"`
+
+	Rerolled     bool `json:"rerolled"`
+	PreviousFace int  `json:"previous_face,omitempty"`
+}
+
+type APIAssist struct {
+	User      APIUser      `json:"user"`
+	Target    int          `json:"target"`
+	Result    APIDieResult `json:"result"`
+	Successes int          `json:"successes"`
+}
+
+type APIOpposedResult struct {
+	Opponent APIRoll  `json:"opponent"`
+	Winner   *APIUser `json:"winner"`
+	Reason   string   `json:"reason"`
+}
+
+type APIExtendedTaskWork struct {
+	ID            int    `json:"id"`
+	Name          string `json:"name"`
+	Work          int    `json:"work"`
+	Breakthroughs int    `json:"breakthroughs"`
+	Complete      bool   `json:"complete"`
+}
+
+type APITaskResult struct {
+	Target        int  `json:"target"`
+	Difficulty    int  `json:"difficulty"`
+	Successes     int  `json:"successes"`
+	Complications int  `json:"complications"`
+	Passed        bool `json:"passed"`
+	Momentum      int  `json:"momentum"`
+}
+
+type APIRoll struct {
+	ID         int                     `json:"id"`
+	Expression string                  `json:"expression"`
+	Modifier   int                     `json:"modifier"`
+	Total      int                     `json:"total"`
+	Results    []APIDieResult          `json:"results"`
+	Challenge  []APIChallengeDieResult `json:"challenge"`
+	Effects    int                     `json:"effects"`
+	Task       *APITaskResult          `json:"task"`
+	Time       time.Time               `json:"time"`
+	User       APIUser                 `json:"user"`
+	Assists    []APIAssist             `json:"assists,omitempty"`
+	Opposed    *APIOpposedResult       `json:"opposed,omitempty"`
+
+	ExtendedTask *APIExtendedTaskWork `json:"extended_task,omitempty"`
+
+	RerollOf     int    `json:"reroll_of,omitempty"`
+	RerollReason string `json:"reroll_reason,omitempty"`
+}
+
+type APIStats struct {
+	Momentum        int                 `json:"momentum"`
+	Threat          int                 `json:"threat"`
+	SceneTraits     []string            `json:"scene_traits"`
+	CharacterTraits map[string][]string `json:"character_traits"`
+	ExtendedTasks   []APIExtendedTask   `json:"extended_tasks"`
+	Characters      []APICharacter      `json:"characters"`
+	Conflict        *APIConflict        `json:"conflict"` // nil unless a conflict is under way
+}
+
+type APIConflict struct {
+	Round      int            `json:"round"`
+	Turn       string         `json:"turn"`    // "players" or "game_master"
+	Current    string         `json:"current"` // empty while the side whose turn it is picks who goes
+	Combatants []APICombatant `json:"combatants"`
+}
+
+type APICombatant struct {
+	Name  string `json:"name"`
+	NPC   bool   `json:"npc"`
+	Acted bool   `json:"acted"`
+}
+
+// APICharacter is the condition of a character with a sheet.
+type APICharacter struct {
+	Character     string   `json:"character"`
+	Stress        int      `json:"stress"`
+	MaxStress     int      `json:"max_stress"`
+	Determination int      `json:"determination"`
+	Injuries      []string `json:"injuries"`
+}
+
+type APIExtendedTask struct {
+	ID            int    `json:"id"`
+	Name          string `json:"name"`
+	Work          int    `json:"work"`
+	Progress      int    `json:"progress"`
+	Magnitude     int    `json:"magnitude"`
+	Resistance    int    `json:"resistance"`
+	Breakthroughs int    `json:"breakthroughs"`
+	Complete      bool   `json:"complete"`
+}
+
+// APIRollRequest is the body of POST /api/v1/rolls. Either Expression or ChallengeDice must be set.
+type APIRollRequest struct {
+	Expression    string `json:"expression"`
+	ChallengeDice int    `json:"challenge_dice"`
+	Target        int    `json:"target"`
+	Difficulty    int    `json:"difficulty"`
+	AddMomentum   bool   `json:"add_momentum"`
+	BuyDice       int    `json:"buy_dice"`      // extra d20s paid for with Threat
+	ExtendedTask  int    `json:"extended_task"` // ID of the extended task the roll is for
+
+	SpendDetermination bool `json:"spend_determination"` // add a d20 that counts as a 1
+}
+
+// APIRerollRequest is the body of POST /api/v1/rolls/{id}/reroll. Dice are counted from 0.
+type APIRerollRequest struct {
+	Dice   []int  `json:"dice"`
+	Reason string `json:"reason"`
+}
+
+// APIStatsRequest is the body of PATCH /api/v1/stats. Only the fields that are set are changed.
+type APIStatsRequest struct {
+	Momentum    *int      `json:"momentum"`
+	Threat      *int      `json:"threat"`
+	SceneTraits *[]string `json:"scene_traits"`
+}
+
+type APISessionRequest struct {
+	Name          string `json:"name"`
+	CharacterName string `json:"character_name"`
+	PartyKey      string `json:"party_key"`
+}
+
+type APIError struct {
+	Status   int    `json:"status"`
+	Message  string `json:"message"`
+	Position *int   `json:"position,omitempty"` // where a dice expression failed to parse
+
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+func newAPIUser(user *User) APIUser {
+	return APIUser{
+		Name:          user.Name,
+		CharacterName: user.CharacterName,
+		Room:          user.Room,
+		IsGameMaster:  user.IsGameMaster,
+	}
+}
+
+func newAPIRoll(roll Roll) APIRoll {
+	result := APIRoll{
+		ID:         roll.ID,
+		Expression: roll.Expression,
+		Modifier:   roll.Modifier,
+		Total:      roll.Total(),
+		Results:    make([]APIDieResult, len(roll.Result)),
+		Challenge:  make([]APIChallengeDieResult, len(roll.Challenge)),
+		Effects:    roll.Challenge.Effects(),
+		Time:       roll.Time,
+
+		ExtendedTask: (*APIExtendedTaskWork)(roll.ExtendedTask),
+		RerollOf:     roll.RerollOf,
+		RerollReason: roll.RerollReason,
+	}
+
+	for i, dieResult := range roll.Result {
+		result.Results[i] = APIDieResult(dieResult)
+	}
+
+	for i, challengeResult := range roll.Challenge {
+		result.Challenge[i] = APIChallengeDieResult(challengeResult)
+	}
+
+	for _, assist := range roll.Assists {
+		result.Assists = append(result.Assists, APIAssist{
+			User:      newAPIUser(assist.User),
+			Target:    assist.Target,
+			Result:    APIDieResult(assist.Result),
+			Successes: assist.Successes(),
+		})
+	}
+
+	if roll.Opposed != nil {
+		result.Opposed = &APIOpposedResult{
+			Opponent: newAPIRoll(roll.Opposed.Opponent),
+			Reason:   roll.Opposed.Reason,
+		}
+
+		if roll.Opposed.Winner != nil {
+			winner := newAPIUser(roll.Opposed.Winner)
+			result.Opposed.Winner = &winner
+		}
+	}
+
+	if roll.Task != nil {
+		task := APITaskResult(*roll.Task)
+		result.Task = &task
+	}
+
+	if roll.User != nil {
+		result.User = newAPIUser(roll.User)
+	}
+
+	return result
+}
+
+func newAPIStats(stats *Stats) APIStats {
+	stats.Mutex.RLock()
+	defer stats.Mutex.RUnlock()
+
+	result := APIStats{
+		Momentum:        stats.Momentum,
+		Threat:          stats.Threat,
+		SceneTraits:     append([]string{}, stats.SceneTraits...),
+		CharacterTraits: make(map[string][]string, len(stats.CharacterTraits)),
+		ExtendedTasks:   []APIExtendedTask{},
+		Characters:      []APICharacter{},
+	}
+
+	for character, traits := range stats.CharacterTraits {
+		result.CharacterTraits[character] = append([]string{}, traits...)
+	}
+
+	for _, task := range stats.ExtendedTasks {
+		result.ExtendedTasks = append(result.ExtendedTasks, APIExtendedTask{
+			ID:            task.ID,
+			Name:          task.Name,
+			Work:          task.Work,
+			Progress:      task.Progress,
+			Magnitude:     task.Magnitude,
+			Resistance:    task.Resistance,
+			Breakthroughs: task.Breakthroughs,
+			Complete:      task.Complete(),
+		})
+	}
+
+	characters := make([]string, 0, len(stats.CharacterSheets))
+	for character := range stats.CharacterSheets {
+		characters = append(characters, character)
+	}
+
+	slices.Sort(characters)
+
+	for _, character := range characters {
+		sheet := stats.CharacterSheets[character]
+
+		result.Characters = append(result.Characters, APICharacter{
+			Character:     sheet.Character,
+			Stress:        sheet.Stress,
+			MaxStress:     sheet.MaxStress(),
+			Determination: sheet.Determination,
+			Injuries:      append([]string{}, sheet.Injuries...),
+		})
+	}
+
+	if conflict := stats.CurrentConflict; conflict != nil {
+		result.Conflict = &APIConflict{
+			Round:      conflict.Round,
+			Turn:       string(conflict.Turn),
+			Current:    conflict.Current,
+			Combatants: make([]APICombatant, 0, len(conflict.Combatants)),
+		}
+
+		for _, combatant := range conflict.Combatants {
+			result.Conflict.Combatants = append(result.Conflict.Combatants, APICombatant(combatant))
+		}
+	}
+
+	return result
+}
+
+// wantsJSON reports whether the client prefers JSON to HTML, based on the Accept header.
+func wantsJSON(req *http.Request) bool {
+	for _, accepted := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+
+		switch mediaType {
+		case "application/json":
+			return true
+		case "text/html", "*/*":
+			return false
+		}
+	}
+
+	return false
+}
+
+// APIMiddleware requires a logged-in user and puts their room in the context. Unlike UserMiddleware, it answers with
+// a 401 instead of redirecting to the login page.
+func (s *Server) APIMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		user, err := s.userFromRequest(writer, req)
+		if err != nil {
+			http.SetCookie(writer, s.resetCookie(CookieData))
+			s.handleErr(writer, req, authErr("invalid session, log in again"))
+
+			return
+		} else if user == nil {
+			s.handleErr(writer, req, authErr("not logged in"))
+			return
+		}
+
+		room, ok := s.Rooms[user.Room]
+		if !ok {
+			s.handleErr(writer, req, authErr("your room no longer exists, log in again"))
+			return
+		}
+
+		ctx := context.WithValue(req.Context(), userKey, user)
+		ctx = context.WithValue(ctx, roomKey, room)
+
+		next(writer, req.WithContext(ctx))
+	}
+}
+
+// APIGameMasterMiddleware answers with a 403 for anybody but the room's Game Master.
+func (s *Server) APIGameMasterMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		if !s.isGameMaster(UserFromContext(req)) {
+			s.handleErr(writer, req, forbiddenErr("only the game master can do that"))
+			return
+		}
+
+		next(writer, req)
+	}
+}
+
+func (s *Server) setupAPIRoutes() {
+	s.Mux.HandleFunc("POST /api/v1/session", s.APILoginHandler)
+	s.Mux.HandleFunc("GET /api/v1/session", s.APIMiddleware(s.APISessionHandler))
+	s.Mux.HandleFunc("DELETE /api/v1/session", s.APILogoutHandler)
+	s.Mux.HandleFunc("GET /api/v1/rolls", s.APIMiddleware(s.APIRollsHandler))
+	s.Mux.HandleFunc("POST /api/v1/rolls", s.APIMiddleware(s.APICreateRollHandler))
+	s.Mux.HandleFunc("GET /api/v1/rolls/{id}", s.APIMiddleware(s.APIRollHandler))
+	s.Mux.HandleFunc("POST /api/v1/rolls/{id}/reroll", s.APIMiddleware(s.APIRerollHandler))
+	s.Mux.HandleFunc("POST /api/v1/private-rolls", s.APIMiddleware(s.APIGameMasterMiddleware(s.APIPrivateRollHandler)))
+	s.Mux.HandleFunc("GET /api/v1/stats", s.APIMiddleware(s.APIStatsHandler))
+	s.Mux.HandleFunc("PATCH /api/v1/stats", s.APIMiddleware(s.APIGameMasterMiddleware(s.APIUpdateStatsHandler)))
+	s.Mux.HandleFunc("/api/", func(writer http.ResponseWriter, req *http.Request) {
+		s.handleErr(writer, req, notFoundErr("unknown API endpoint"))
+	})
+}
+
+func (s *Server) APILoginHandler(writer http.ResponseWriter, req *http.Request) {
+	body := APISessionRequest{}
+	if !s.decodeAPIBody(writer, req, &body) {
+		return
+	}
+
+	if body.Name == "" {
+		s.handleErr(writer, req, validationErr("name is required"))
+		return
+	}
+
+	room := s.roomFromPartyKey(body.PartyKey)
+	if room == nil {
+		s.handleErr(writer, req, authErr("invalid party key"))
+		return
+	}
+
+	user := &User{
+		Name:          body.Name,
+		CharacterName: body.CharacterName,
+		Room:          room.Name(),
+		IPAddress:     requestIP(req),
+	}
+
+	dataCookie, err := user.DataCookie(s.keys.Current)
+	if err != nil {
+		s.handleErr(writer, req, internalErr(err, "failed to save cookie"))
+		return
+	}
+
+	http.SetCookie(writer, dataCookie)
+	s.apiJSON(writer, http.StatusCreated, newAPIUser(user))
+}
+
+func (s *Server) APISessionHandler(writer http.ResponseWriter, req *http.Request) {
+	s.apiJSON(writer, http.StatusOK, newAPIUser(UserFromContext(req)))
+}
+
+func (s *Server) APILogoutHandler(writer http.ResponseWriter, _ *http.Request) {
+	http.SetCookie(writer, s.resetCookie(CookieData))
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// APIRollsHandler lists the room's rolls, newest first. "limit" caps how many are returned and "before" only returns
+// rolls with a lower ID, for paging.
+func (s *Server) APIRollsHandler(writer http.ResponseWriter, req *http.Request) {
+	room := RoomFromContext(req)
+
+	limit, err := queryInt(req, "limit", apiDefaultLimit)
+	if err != nil || limit < 1 || limit > apiMaxLimit {
+		s.handleErr(writer, req, validationErr("limit must be between 1 and %d", apiMaxLimit))
+		return
+	}
+
+	before, err := queryInt(req, "before", 0)
+	if err != nil || before < 0 {
+		s.handleErr(writer, req, validationErr("before must be a roll ID"))
+		return
+	}
+
+	rolls := make([]APIRoll, 0, limit)
+
+	for _, roll := range room.History() {
+		if len(rolls) == limit {
+			break
+		}
+
+		if before == 0 || roll.ID < before {
+			rolls = append(rolls, newAPIRoll(roll))
+		}
+	}
+
+	s.apiJSON(writer, http.StatusOK, rolls)
+}
+
+func (s *Server) APIRollHandler(writer http.ResponseWriter, req *http.Request) {
+	room := RoomFromContext(req)
+
+	id, err := strconv.Atoi(req.PathValue("id"))
+	if err != nil {
+		s.handleErr(writer, req, validationErr("roll ID must be a number"))
+		return
+	}
+
+	roll, ok := room.FindRoll(id)
+	if !ok {
+		s.handleErr(writer, req, notFoundErr("no roll with ID %d", id))
+		return
+	}
+
+	s.apiJSON(writer, http.StatusOK, newAPIRoll(roll))
+}
+
+func (s *Server) APICreateRollHandler(writer http.ResponseWriter, req *http.Request) {
+	user := UserFromContext(req)
+	room := RoomFromContext(req)
+
+	body := APIRollRequest{}
+	if !s.decodeAPIBody(writer, req, &body) {
+		return
+	}
+
+	expr, err := apiDiceExpression(body)
+	if err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	if body.ExtendedTask != 0 {
+		if err := room.checkExtendedTask(body.ExtendedTask); err != nil {
+			s.handleErr(writer, req, err)
+			return
+		}
+
+		expr.ExtendedTask = body.ExtendedTask
+	}
+
+	if err := s.Opts.Config.DiceLimits.Check(expr); err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	if expr.Determination {
+		if err := room.Stats.SpendDetermination(user.CharacterName); err != nil {
+			s.handleErr(writer, req, err)
+			return
+		}
+	}
+
+	roll, err := room.Roll(user, expr, body.AddMomentum)
+	if err != nil {
+		s.handleErr(writer, req, internalErr(err, "failed to save roll"))
+		return
+	}
+
+	writer.Header().Set("Location", fmt.Sprintf("/api/v1/rolls/%d", roll.ID))
+	s.apiJSON(writer, http.StatusCreated, newAPIRoll(roll))
+}
+
+func (s *Server) APIRerollHandler(writer http.ResponseWriter, req *http.Request) {
+	user := UserFromContext(req)
+	room := RoomFromContext(req)
+
+	id, err := strconv.Atoi(req.PathValue("id"))
+	if err != nil {
+		s.handleErr(writer, req, validationErr("roll ID must be a number"))
+		return
+	}
+
+	body := APIRerollRequest{}
+	if !s.decodeAPIBody(writer, req, &body) {
+		return
+	}
+
+	roll, err := room.Reroll(user, s.Opts.Config.Rerolls, id, body.Dice, body.Reason)
+	if err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	writer.Header().Set("Location", fmt.Sprintf("/api/v1/rolls/%d", roll.ID))
+	s.apiJSON(writer, http.StatusCreated, newAPIRoll(roll))
+}
+
+func (s *Server) APIPrivateRollHandler(writer http.ResponseWriter, req *http.Request) {
+	user := UserFromContext(req)
+
+	body := APIRollRequest{}
+	if !s.decodeAPIBody(writer, req, &body) {
+		return
+	}
+
+	if body.BuyDice != 0 || body.ExtendedTask != 0 || body.SpendDetermination {
+		s.handleErr(writer, req, validationErr("buy_dice, extended_task and spend_determination are only for public rolls"))
+		return
+	}
+
+	expr, err := apiDiceExpression(body)
+	if err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	if err := s.Opts.Config.DiceLimits.Check(expr); err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	s.apiJSON(writer, http.StatusOK, newAPIRoll(expr.Roll(user)))
+}
+
+func (s *Server) APIStatsHandler(writer http.ResponseWriter, req *http.Request) {
+	room := RoomFromContext(req)
+
+	s.apiJSON(writer, http.StatusOK, newAPIStats(room.Stats))
+}
+
+func (s *Server) APIUpdateStatsHandler(writer http.ResponseWriter, req *http.Request) {
+	room := RoomFromContext(req)
+
+	body := APIStatsRequest{}
+	if !s.decodeAPIBody(writer, req, &body) {
+		return
+	}
+
+	if (body.Momentum != nil && (*body.Momentum < 0 || *body.Momentum > MaxMomentum)) || (body.Threat != nil && *body.Threat < 0) {
+		s.handleErr(writer, req, validationErr("momentum must be between 0 and %d, and threat can't be negative", MaxMomentum))
+		return
+	}
+
+	if body.SceneTraits != nil {
+		room.Stats.SetSceneTraits(*body.SceneTraits)
+	}
+
+	_, changed, err := room.SetPools(UserFromContext(req), body.Momentum, body.Threat)
+	if err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	if !changed {
+		if err := room.saveStats(); err != nil {
+			s.handleErr(writer, req, internalErr(err, "failed to save stats"))
+			return
+		}
+
+		room.NotifyClients(EventTypeStats)
+	}
+
+	s.apiJSON(writer, http.StatusOK, newAPIStats(room.Stats))
+}
+
+// apiDiceExpression builds the dice expression for a roll request.
+func apiDiceExpression(body APIRollRequest) (*DiceExpression, error) {
+	var expr *DiceExpression
+
+	switch {
+	case body.Expression != "" && body.ChallengeDice != 0:
+		return nil, validationErr("expression and challenge_dice can't both be set")
+	case body.Expression != "":
+		parsed, err := ParseDiceNotation(body.Expression)
+		if err != nil {
+			return nil, err
+		}
+
+		expr = parsed
+	case body.ChallengeDice > 0:
+		expr = &DiceExpression{Challenge: true, Count: body.ChallengeDice, Sides: 6}
+	default:
+		return nil, validationErr("expression or challenge_dice is required")
+	}
+
+	if !expr.Challenge {
+		if body.Target != 0 && expr.Target == 0 {
+			expr.Target = body.Target
+		}
+
+		expr.Difficulty = body.Difficulty
+	}
+
+	if err := expr.BuyDice(body.BuyDice); err != nil {
+		return nil, err
+	}
+
+	if body.SpendDetermination {
+		if err := expr.AddDetermination(); err != nil {
+			return nil, err
+		}
+	}
+
+	return expr, nil
+}
+
+// decodeAPIBody decodes a JSON request body into target, answering with a 4xx if it can't.
+func (s *Server) decodeAPIBody(writer http.ResponseWriter, req *http.Request, target any) bool {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		s.apiErr(writer, http.StatusUnsupportedMediaType, "request body must be application/json")
+		return false
+	}
+
+	decoder := json.NewDecoder(http.MaxBytesReader(writer, req.Body, apiMaxBodySize))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(target); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			s.apiErr(writer, http.StatusRequestEntityTooLarge, "request body is too large")
+			return false
+		}
+
+		s.handleErr(writer, req, validationErr("invalid JSON body: %v", err))
+
+		return false
+	}
+
+	return true
+}
+
+func queryInt(req *http.Request, key string, fallback int) (int, error) {
+	raw := req.URL.Query().Get(key)
+	if raw == "" {
+		return fallback, nil
+	}
+
+	return strconv.Atoi(raw)
+}
+
+func (s *Server) apiJSON(writer http.ResponseWriter, status int, data any) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+
+	if err := json.NewEncoder(writer).Encode(data); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write API response: %v\n", err)
+	}
+}
+
+func (s *Server) apiErr(writer http.ResponseWriter, status int, message string) {
+	s.apiJSON(writer, status, APIError{Status: status, Message: message})
+}
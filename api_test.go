@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestAPIErrors(t *testing.T) {
+	server := newTestServer(t)
+	player := testPlayer("p", "Kirk")
+
+	tests := []struct {
+		name    string
+		user    *User
+		method  string
+		path    string
+		body    any
+		status  int
+		message string
+		fields  []string
+	}{
+		{
+			name:    "not logged in",
+			method:  "GET",
+			path:    "/api/v1/rolls",
+			status:  http.StatusUnauthorized,
+			message: "not logged in",
+		},
+		{
+			name:    "wrong party key",
+			method:  "POST",
+			path:    "/api/v1/session",
+			body:    APISessionRequest{Name: "p", PartyKey: "NOPE"},
+			status:  http.StatusUnauthorized,
+			message: "invalid party key",
+		},
+		{
+			name:    "form instead of JSON",
+			user:    player,
+			method:  "POST",
+			path:    "/api/v1/rolls",
+			body:    "expression=2d20",
+			status:  http.StatusUnsupportedMediaType,
+			message: "request body must be application/json",
+		},
+		{
+			name:    "no dice",
+			user:    player,
+			method:  "POST",
+			path:    "/api/v1/rolls",
+			body:    APIRollRequest{},
+			status:  http.StatusBadRequest,
+			message: "expression or challenge_dice is required",
+		},
+		{
+			name:    "past the dice limits",
+			user:    player,
+			method:  "POST",
+			path:    "/api/v1/rolls",
+			body:    APIRollRequest{Expression: "30d7+200"},
+			status:  http.StatusBadRequest,
+			message: "Can't roll 30d7+200.",
+			fields:  []string{"count", "sides", "modifier"},
+		},
+		{
+			name:    "limit too high",
+			user:    player,
+			method:  "GET",
+			path:    fmt.Sprintf("/api/v1/rolls?limit=%d", apiMaxLimit+1),
+			status:  http.StatusBadRequest,
+			message: fmt.Sprintf("limit must be between 1 and %d", apiMaxLimit),
+		},
+		{
+			name:    "no such roll",
+			user:    player,
+			method:  "GET",
+			path:    "/api/v1/rolls/9",
+			status:  http.StatusNotFound,
+			message: "no roll with ID 9",
+		},
+		{
+			name:    "players can't change stats",
+			user:    player,
+			method:  "PATCH",
+			path:    "/api/v1/stats",
+			body:    APIStatsRequest{},
+			status:  http.StatusForbidden,
+			message: "only the game master can do that",
+		},
+		{
+			name:    "claiming to be the Game Master without the claim",
+			user:    &User{Name: "GM", Room: defaultRoomName, IsGameMaster: true},
+			method:  "POST",
+			path:    "/api/v1/private-rolls",
+			body:    APIRollRequest{Expression: "2d20"},
+			status:  http.StatusForbidden,
+			message: "only the game master can do that",
+		},
+		{
+			name:    "unknown endpoint",
+			user:    player,
+			method:  "GET",
+			path:    "/api/v1/nope",
+			status:  http.StatusNotFound,
+			message: "unknown API endpoint",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := serve(t, server, test.user, test.method, test.path, test.body)
+			wantStatus(t, recorder, test.status)
+
+			apiErr := APIError{}
+			decodeJSON(t, recorder, &apiErr)
+
+			if apiErr.Status != test.status || apiErr.Message != test.message {
+				t.Errorf("got %d %q, want %d %q", apiErr.Status, apiErr.Message, test.status, test.message)
+			}
+
+			var fields []string
+			for _, field := range apiErr.Fields {
+				fields = append(fields, field.Field)
+			}
+
+			if !reflect.DeepEqual(fields, test.fields) {
+				t.Errorf("got fields %v, want %v", fields, test.fields)
+			}
+		})
+	}
+}
+
+func TestAPIRolls(t *testing.T) {
+	server := newTestServer(t)
+	player := testPlayer("p", "Kirk")
+
+	var ids []int
+
+	for _, expression := range []string{"2d20t10", "3d6", "1d20"} {
+		recorder := serve(t, server, player, "POST", "/api/v1/rolls", APIRollRequest{Expression: expression})
+		wantStatus(t, recorder, http.StatusCreated)
+
+		roll := APIRoll{}
+		decodeJSON(t, recorder, &roll)
+
+		if location := recorder.Header().Get("Location"); location != fmt.Sprintf("/api/v1/rolls/%d", roll.ID) {
+			t.Errorf("got Location %q for roll %d", location, roll.ID)
+		}
+
+		ids = append(ids, roll.ID)
+	}
+
+	recorder := serve(t, server, player, "GET", fmt.Sprintf("/api/v1/rolls/%d", ids[0]), nil)
+	wantStatus(t, recorder, http.StatusOK)
+
+	roll := APIRoll{}
+	decodeJSON(t, recorder, &roll)
+
+	if roll.Expression != "2d20t10" || roll.Task == nil || roll.Task.Target != 10 || roll.User.CharacterName != "Kirk" {
+		t.Errorf("got %+v, want Kirk's 2d20t10 task", roll)
+	}
+
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{"", []int{ids[2], ids[1], ids[0]}},
+		{"?limit=2", []int{ids[2], ids[1]}},
+		{fmt.Sprintf("?limit=2&before=%d", ids[1]), []int{ids[0]}},
+	}
+
+	for _, test := range tests {
+		recorder := serve(t, server, player, "GET", "/api/v1/rolls"+test.query, nil)
+		wantStatus(t, recorder, http.StatusOK)
+
+		var rolls []APIRoll
+		decodeJSON(t, recorder, &rolls)
+
+		var got []int
+		for _, roll := range rolls {
+			got = append(got, roll.ID)
+		}
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("GET /api/v1/rolls%s: got %v, want %v", test.query, got, test.want)
+		}
+	}
+}
+
+func TestAPIStats(t *testing.T) {
+	server := newTestServer(t)
+	gm := testGameMaster(server)
+
+	momentum, threat := 4, 3
+
+	recorder := serve(t, server, gm, "PATCH", "/api/v1/stats", APIStatsRequest{Momentum: &momentum, Threat: &threat})
+	wantStatus(t, recorder, http.StatusOK)
+
+	recorder = serve(t, server, testPlayer("p", "Kirk"), "GET", "/api/v1/stats", nil)
+	wantStatus(t, recorder, http.StatusOK)
+
+	stats := APIStats{}
+	decodeJSON(t, recorder, &stats)
+
+	if stats.Momentum != momentum || stats.Threat != threat {
+		t.Errorf("got Momentum %d and Threat %d, want %d and %d", stats.Momentum, stats.Threat, momentum, threat)
+	}
+
+	momentum = MaxMomentum + 1
+
+	recorder = serve(t, server, gm, "PATCH", "/api/v1/stats", APIStatsRequest{Momentum: &momentum})
+	wantStatus(t, recorder, http.StatusBadRequest)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+// ChallengeDie is a d6 read as 1 = 1, 2 = 2, 3-4 = blank and 5-6 = 1 plus an Effect.
+type ChallengeDie struct{}
+
+func (c ChallengeDie) Roll() ChallengeDieResult {
+	return newChallengeDieResult(1 + rand.IntN(6))
+}
+
+// newChallengeDieResult reads a challenge die that landed on face.
+func newChallengeDieResult(face int) ChallengeDieResult {
+	result := ChallengeDieResult{Face: face}
+
+	switch face {
+	case 1, 2:
+		result.Value = face
+	case 5, 6:
+		result.Value = 1
+		result.Effect = true
+	}
+
+	return result
+}
+
+type ChallengeDice []ChallengeDie
+
+func NewChallengeDice(num int) ChallengeDice {
+	return make(ChallengeDice, num)
+}
+
+func (c ChallengeDice) Roll(user *User) Roll {
+	result := make(ChallengeDiceResults, len(c))
+
+	for i, die := range c {
+		result[i] = die.Roll()
+	}
+
+	roll := Roll{
+		Challenge: result,
+		Time:      time.Now(),
+		User:      user,
+	}
+
+	return roll
+}
+
+type ChallengeDieResult struct {
+	Face   int  `json:"face"`
+	Value  int  `json:"value"`
+	Effect bool `json:"effect"`
+
+	Rerolled     bool `json:"rerolled,omitempty"`
+	PreviousFace int  `json:"previous_face,omitempty"` // the face before the die was rerolled
+}
+
+type ChallengeDiceResults []ChallengeDieResult
+
+func (c ChallengeDiceResults) Total() int {
+	total := 0
+
+	for _, result := range c {
+		total += result.Value
+	}
+
+	return total
+}
+
+func (c ChallengeDiceResults) Effects() int {
+	effects := 0
+
+	for _, result := range c {
+		if result.Effect {
+			effects++
+		}
+	}
+
+	return effects
+}
+
+func (c ChallengeDiceResults) String() string {
+	result := ""
+
+	for _, dieResult := range c {
+		result += fmt.Sprintf("%d | ", dieResult.Value)
+	}
+
+	result = strings.TrimSuffix(result, " | ")
+
+	return result
+}
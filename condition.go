@@ -0,0 +1,38 @@
+package main
+
+// determinationReason is the reroll reason that spends a point of Determination.
+const determinationReason = "Determination"
+
+// MaxStress is how much Stress the character can take before they're out of the scene: their Fitness plus Security.
+func (c *CharacterSheet) MaxStress() int {
+	return c.Attributes["Fitness"] + c.Disciplines["Security"]
+}
+
+// SpendDetermination takes a point of Determination from character's sheet.
+func (s *Stats) SpendDetermination(character string) error {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	sheet, ok := s.CharacterSheets[character]
+
+	switch {
+	case !ok:
+		return validationErr("Fill in your character sheet to spend Determination.")
+	case sheet.Determination < 1:
+		return validationErr("%s has no Determination left.", character)
+	}
+
+	sheet.Determination--
+
+	return nil
+}
+
+// RefundDetermination gives back a point of Determination spent on a roll that couldn't be saved.
+func (s *Stats) RefundDetermination(character string) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	if sheet, ok := s.CharacterSheets[character]; ok {
+		sheet.Determination++
+	}
+}
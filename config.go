@@ -1,20 +1,139 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	defaultKeyRotationGrace = 24 * time.Hour
+	defaultRoomName         = "default"
+)
+
+var roomNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
 
 type Config struct {
-	GameMasterName string `json:"game_master_name"`
-	PartyKey       string `json:"party_key"`
+	// GameMasterName, GameMasterPasswordHash and PartyKey configure a single room named "default" when Rooms is empty.
+	GameMasterName         string `json:"game_master_name"`
+	GameMasterPasswordHash string `json:"game_master_password_hash"` // bcrypt, see "d20 hash-password"
+	PartyKey               string `json:"party_key"`
+
+	Rooms []*RoomConfig `json:"rooms"`
+
+	SecretKey         string `json:"secret_key"`          // hex-encoded, 32 bytes
+	PreviousSecretKey string `json:"previous_secret_key"` // hex-encoded, 32 bytes
+	SecretKeyFile     string `json:"secret_key_file"`
+	RotationGrace     string `json:"key_rotation_grace"` // e.g. "24h"
+	KeyRotatedAt      string `json:"key_rotated_at"`     // RFC 3339, when previous_secret_key was replaced
+
+	DiceLimits *DiceLimits  `json:"dice_limits"` // defaults apply to anything left out
+	Rerolls    *RerollRules `json:"rerolls"`     // defaults apply to anything left out
 }
 
-func (c *Config) OK() error {
-	if c.GameMasterName == "" {
+type RoomConfig struct {
+	Name                   string `json:"name"` // used in URLs, e.g. /r/{name}/dice
+	GameMasterName         string `json:"game_master_name"`
+	GameMasterPasswordHash string `json:"game_master_password_hash"`
+	PartyKey               string `json:"party_key"`
+}
+
+func (r *RoomConfig) OK() error {
+	if !roomNameRegexp.MatchString(r.Name) {
+		return fmt.Errorf("room name %q must only contain lowercase letters, numbers and dashes", r.Name)
+	}
+
+	if r.GameMasterName == "" {
 		return fmt.Errorf("must supply game master name")
 	}
 
-	if c.PartyKey == "" {
+	if r.GameMasterPasswordHash == "" {
+		// configs from before Game Master passwords only had the name, which anybody could log in with
+		return fmt.Errorf("must supply game master password hash: run \"d20 hash-password\" and set " +
+			"game_master_password_hash to its output")
+	}
+
+	if _, err := bcrypt.Cost([]byte(r.GameMasterPasswordHash)); err != nil {
+		return fmt.Errorf("invalid game master password hash: %w", err)
+	}
+
+	if r.PartyKey == "" {
 		return fmt.Errorf("must supply party key")
 	}
 
 	return nil
 }
+
+func (c *Config) OK() error {
+	if len(c.Rooms) == 0 {
+		c.Rooms = []*RoomConfig{{
+			Name:                   defaultRoomName,
+			GameMasterName:         c.GameMasterName,
+			GameMasterPasswordHash: c.GameMasterPasswordHash,
+			PartyKey:               c.PartyKey,
+		}}
+	} else if c.PartyKey != "" {
+		return fmt.Errorf("party key must be set per room when rooms are configured")
+	}
+
+	names := map[string]bool{}
+	partyKeys := map[string]bool{}
+
+	for _, room := range c.Rooms {
+		if err := room.OK(); err != nil {
+			return fmt.Errorf("room %q: %w", room.Name, err)
+		}
+
+		if names[room.Name] {
+			return fmt.Errorf("room %q is configured more than once", room.Name)
+		}
+
+		// players are sent to their room based on the party key they enter
+		if partyKeys[room.PartyKey] {
+			return fmt.Errorf("room %q: party key is already used by another room", room.Name)
+		}
+
+		names[room.Name] = true
+		partyKeys[room.PartyKey] = true
+	}
+
+	if c.DiceLimits == nil {
+		c.DiceLimits = &DiceLimits{}
+	}
+
+	c.DiceLimits.withDefaults()
+
+	if err := c.DiceLimits.OK(); err != nil {
+		return fmt.Errorf("invalid dice limits: %w", err)
+	}
+
+	if c.Rerolls == nil {
+		c.Rerolls = &RerollRules{}
+	}
+
+	c.Rerolls.withDefaults()
+
+	if err := c.Rerolls.OK(); err != nil {
+		return fmt.Errorf("invalid reroll rules: %w", err)
+	}
+
+	if c.RotationGrace != "" {
+		if _, err := time.ParseDuration(c.RotationGrace); err != nil {
+			return fmt.Errorf("invalid key rotation grace period: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// KeyRotationGrace is how long the previous secret key is accepted after the key is rotated.
+func (c *Config) KeyRotationGrace() time.Duration {
+	grace, err := time.ParseDuration(c.RotationGrace)
+	if err != nil {
+		return defaultKeyRotationGrace
+	}
+
+	return grace
+}
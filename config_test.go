@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestConfigOK(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr string
+	}{
+		{
+			name:   "single room",
+			config: Config{GameMasterName: "GM", GameMasterPasswordHash: string(hash), PartyKey: "KEY"},
+		},
+		{
+			name:    "config from before Game Master passwords",
+			config:  Config{GameMasterName: "GM", PartyKey: "KEY"},
+			wantErr: `room "default": must supply game master password hash: run "d20 hash-password"`,
+		},
+		{
+			name:    "password instead of a hash",
+			config:  Config{GameMasterName: "GM", GameMasterPasswordHash: "hunter2", PartyKey: "KEY"},
+			wantErr: `room "default": invalid game master password hash`,
+		},
+		{
+			name: "room without a hash",
+			config: Config{Rooms: []*RoomConfig{
+				{Name: "tuesday", GameMasterName: "GM", GameMasterPasswordHash: string(hash), PartyKey: "KEY1"},
+				{Name: "saturday", GameMasterName: "GM", PartyKey: "KEY2"},
+			}},
+			wantErr: `room "saturday": must supply game master password hash`,
+		},
+		{
+			name: "shared party key",
+			config: Config{Rooms: []*RoomConfig{
+				{Name: "tuesday", GameMasterName: "GM", GameMasterPasswordHash: string(hash), PartyKey: "KEY"},
+				{Name: "saturday", GameMasterName: "GM", GameMasterPasswordHash: string(hash), PartyKey: "KEY"},
+			}},
+			wantErr: `room "saturday": party key is already used by another room`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.config.OK()
+
+			switch {
+			case test.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case test.wantErr != "" && (err == nil || !strings.HasPrefix(err.Error(), test.wantErr)):
+				t.Fatalf("got error %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
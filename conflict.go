@@ -0,0 +1,493 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// keepInitiativeCost is the Threat it takes to keep the initiative: the players add it, the Game Master spends it.
+const keepInitiativeCost = 2
+
+// ConflictSide is one of the two sides that take turns in a conflict.
+type ConflictSide string
+
+const (
+	SidePlayers    ConflictSide = "players"
+	SideGameMaster ConflictSide = "game_master"
+)
+
+func (c ConflictSide) Other() ConflictSide {
+	if c == SidePlayers {
+		return SideGameMaster
+	}
+
+	return SidePlayers
+}
+
+// Possessive names the side for "... turn".
+func (c ConflictSide) Possessive() string {
+	if c == SideGameMaster {
+		return "the Game Master's"
+	}
+
+	return "the players'"
+}
+
+// Combatant is a player character or NPC taking turns in a conflict.
+type Combatant struct {
+	Name  string `json:"name"`
+	NPC   bool   `json:"npc"`
+	Acted bool   `json:"acted"` // they've had their turn this round
+}
+
+func (c Combatant) Side() ConflictSide {
+	if c.NPC {
+		return SideGameMaster
+	}
+
+	return SidePlayers
+}
+
+// CanAct reports whether user decides what the combatant does: the Game Master for NPCs, and the player or the Game
+// Master for characters.
+func (c Combatant) CanAct(user *User) bool {
+	return user.IsGameMaster || (!c.NPC && user.CharacterName == c.Name)
+}
+
+// Conflict is a combat or other conflict where the players' characters and the Game Master's NPCs take turns. The
+// sides alternate, each picking which of their combatants goes next, unless a side keeps the initiative for Threat.
+// Once everyone has acted, a new round starts with the side that didn't go last.
+type Conflict struct {
+	Round      int          `json:"round"`
+	Combatants []Combatant  `json:"combatants"`
+	Turn       ConflictSide `json:"turn"`    // the side acting now
+	Current    string       `json:"current"` // the combatant acting now, or "" while their side picks who goes
+}
+
+// Waiting returns the combatants on side who haven't acted this round.
+func (c *Conflict) Waiting(side ConflictSide) []Combatant {
+	var waiting []Combatant
+
+	for _, combatant := range c.Combatants {
+		if combatant.Side() == side && !combatant.Acted {
+			waiting = append(waiting, combatant)
+		}
+	}
+
+	return waiting
+}
+
+// CanTakeTurn returns the combatants user can pick to act next.
+func (c *Conflict) CanTakeTurn(user *User) []Combatant {
+	if c.Current != "" {
+		return nil
+	}
+
+	var combatants []Combatant
+
+	for _, combatant := range c.Waiting(c.Turn) {
+		if combatant.CanAct(user) {
+			combatants = append(combatants, combatant)
+		}
+	}
+
+	return combatants
+}
+
+// CanEndTurn reports whether user can end the current combatant's turn.
+func (c *Conflict) CanEndTurn(user *User) bool {
+	index := c.index(c.Current)
+
+	return index >= 0 && c.Combatants[index].CanAct(user)
+}
+
+func (c *Conflict) index(name string) int {
+	if name == "" {
+		return -1
+	}
+
+	return slices.IndexFunc(c.Combatants, func(combatant Combatant) bool { return combatant.Name == name })
+}
+
+// advance passes the turn to the other side, or back to the same side if keep is set or the other side has nobody left
+// to act. A new round starts once everyone has acted.
+func (c *Conflict) advance(keep bool) {
+	c.Current = ""
+
+	switch {
+	case keep && len(c.Waiting(c.Turn)) > 0:
+		return
+	case len(c.Waiting(c.Turn.Other())) > 0:
+		c.Turn = c.Turn.Other()
+		return
+	case len(c.Waiting(c.Turn)) > 0:
+		return
+	}
+
+	c.Round++
+
+	for i := range c.Combatants {
+		c.Combatants[i].Acted = false
+	}
+
+	if len(c.Waiting(c.Turn.Other())) > 0 {
+		c.Turn = c.Turn.Other()
+	}
+}
+
+func (c *Conflict) clone() *Conflict {
+	conflict := *c
+	conflict.Combatants = slices.Clone(c.Combatants)
+
+	return &conflict
+}
+
+// Conflict returns a copy of the conflict under way, or nil if there isn't one.
+func (s *Stats) Conflict() *Conflict {
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+
+	if s.CurrentConflict == nil {
+		return nil
+	}
+
+	return s.CurrentConflict.clone()
+}
+
+// StartConflict starts round 1 with first to act, or the other side if first has nobody in the conflict.
+func (s *Stats) StartConflict(characters, npcs []string, first ConflictSide) error {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	if s.CurrentConflict != nil {
+		return validationErr("A conflict is already under way.")
+	}
+
+	conflict := &Conflict{Round: 1, Turn: first}
+	seen := map[string]bool{}
+
+	add := func(name string, npc bool) error {
+		if seen[name] {
+			return validationErr("%s can only join the conflict once.", name)
+		}
+
+		seen[name] = true
+		conflict.Combatants = append(conflict.Combatants, Combatant{Name: name, NPC: npc})
+
+		return nil
+	}
+
+	for _, character := range characters {
+		if err := add(character, false); err != nil {
+			return err
+		}
+	}
+
+	for _, npc := range npcs {
+		if err := add(npc, true); err != nil {
+			return err
+		}
+	}
+
+	if len(conflict.Combatants) == 0 {
+		return validationErr("Choose who is in the conflict.")
+	}
+
+	if len(conflict.Waiting(first)) == 0 {
+		conflict.Turn = first.Other()
+	}
+
+	s.CurrentConflict = conflict
+
+	return nil
+}
+
+// TakeTurn makes name the combatant acting now for user.
+func (s *Stats) TakeTurn(user *User, name string) error {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	conflict := s.CurrentConflict
+	if conflict == nil {
+		return notFoundErr("There is no conflict under way.")
+	}
+
+	index := conflict.index(name)
+	if index < 0 {
+		return notFoundErr("%s isn't in the conflict.", name)
+	}
+
+	combatant := conflict.Combatants[index]
+
+	switch {
+	case !combatant.CanAct(user):
+		return forbiddenErr("You can't take %s's turn.", name)
+	case conflict.Current != "":
+		return validationErr("%s is still taking their turn.", conflict.Current)
+	case combatant.Side() != conflict.Turn:
+		return validationErr("It's %s turn.", conflict.Turn.Possessive())
+	case combatant.Acted:
+		return validationErr("%s has already acted this round.", name)
+	}
+
+	conflict.Combatants[index].Acted = true
+	conflict.Current = name
+
+	return nil
+}
+
+// EndTurn ends the current combatant's turn for user. Keeping the initiative gives the next turn to their own side for
+// keepInitiativeCost Threat, which the players add and the Game Master spends. It returns the change to Threat.
+func (s *Stats) EndTurn(user *User, keep bool) (int, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	conflict := s.CurrentConflict
+
+	switch {
+	case conflict == nil:
+		return 0, notFoundErr("There is no conflict under way.")
+	case conflict.Current == "":
+		return 0, validationErr("Nobody is taking a turn.")
+	case !conflict.CanEndTurn(user):
+		return 0, forbiddenErr("Only %s's side can end their turn.", conflict.Current)
+	}
+
+	threat := 0
+
+	if keep {
+		switch {
+		case len(conflict.Waiting(conflict.Turn)) == 0:
+			return 0, validationErr("Everyone on %s's side has already acted this round.", conflict.Current)
+		case conflict.Turn == SideGameMaster && s.Threat < keepInitiativeCost:
+			return 0, validationErr("Keeping the initiative takes %d Threat, and there is only %d.", keepInitiativeCost,
+				s.Threat)
+		case conflict.Turn == SideGameMaster:
+			threat = -keepInitiativeCost
+		default:
+			threat = keepInitiativeCost
+		}
+
+		s.Threat += threat
+	}
+
+	conflict.advance(keep)
+
+	return threat, nil
+}
+
+// RemoveCombatant takes name out of the conflict, e.g. once they're defeated. If it was their turn, it passes on.
+func (s *Stats) RemoveCombatant(name string) error {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	conflict := s.CurrentConflict
+	if conflict == nil {
+		return notFoundErr("There is no conflict under way.")
+	}
+
+	index := conflict.index(name)
+	if index < 0 {
+		return notFoundErr("%s isn't in the conflict.", name)
+	}
+
+	conflict.Combatants = slices.Delete(conflict.Combatants, index, index+1)
+
+	if conflict.Current == name {
+		conflict.advance(false)
+	} else if conflict.Current == "" && len(conflict.Waiting(conflict.Turn)) == 0 {
+		// their side was picking and nobody is left to pick
+		conflict.advance(false)
+	}
+
+	return nil
+}
+
+// EndConflict reports whether there was a conflict to end.
+func (s *Stats) EndConflict() bool {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	ended := s.CurrentConflict != nil
+	s.CurrentConflict = nil
+
+	return ended
+}
+
+// EndTurn ends the current combatant's turn, recording the Threat paid to keep the initiative in the ledger.
+func (r *Room) EndTurn(user *User, keep bool) error {
+	current := ""
+	if conflict := r.Stats.Conflict(); conflict != nil {
+		current = conflict.Current
+	}
+
+	threat, err := r.Stats.EndTurn(user, keep)
+	if err != nil {
+		return err
+	}
+
+	if threat == 0 {
+		return r.publishConflict()
+	}
+
+	transaction := Transaction{
+		Kind:   TransactionInitiative,
+		User:   user,
+		Reason: fmt.Sprintf("Kept the initiative after %s's turn", current),
+		Threat: threat,
+	}
+
+	_, err = r.recordTransaction(transaction)
+
+	return err
+}
+
+// publishConflict saves the conflict with the stats and shows everyone the new turn order.
+func (r *Room) publishConflict() error {
+	if err := r.saveStats(); err != nil {
+		return err
+	}
+
+	r.NotifyClients(EventTypeStats)
+
+	return nil
+}
+
+func (s *Server) ConflictHandler(writer http.ResponseWriter, req *http.Request) {
+	s.renderConflict(writer, req)
+}
+
+func (s *Server) StartConflictHandler(writer http.ResponseWriter, req *http.Request) {
+	room := RoomFromContext(req)
+
+	if err := req.ParseForm(); err != nil {
+		s.handleErr(writer, req, validationErr("Failed to parse form: %v", err))
+		return
+	}
+
+	var characters []string
+
+	for _, character := range req.Form["characters"] {
+		if character = strings.TrimSpace(character); character != "" {
+			characters = append(characters, character)
+		}
+	}
+
+	// NPCs ticked from the roster, then any others typed in
+	npcs := slices.DeleteFunc(slices.Clone(req.Form["roster"]), func(npc string) bool { return npc == "" })
+	npcs = append(npcs, splitList(req.Form.Get("npcs"))...)
+
+	first := SidePlayers
+	if req.Form.Get("first") == string(SideGameMaster) {
+		first = SideGameMaster
+	}
+
+	if err := room.Stats.StartConflict(characters, npcs, first); err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	s.conflictChanged(writer, req)
+}
+
+func (s *Server) TakeTurnHandler(writer http.ResponseWriter, req *http.Request) {
+	user := UserFromContext(req)
+	room := RoomFromContext(req)
+
+	if err := req.ParseForm(); err != nil {
+		s.handleErr(writer, req, validationErr("Failed to parse form: %v", err))
+		return
+	}
+
+	if err := room.Stats.TakeTurn(user, req.Form.Get("combatant")); err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	s.conflictChanged(writer, req)
+}
+
+func (s *Server) EndTurnHandler(writer http.ResponseWriter, req *http.Request) {
+	user := UserFromContext(req)
+	room := RoomFromContext(req)
+
+	if err := req.ParseForm(); err != nil {
+		s.handleErr(writer, req, validationErr("Failed to parse form: %v", err))
+		return
+	}
+
+	if err := room.EndTurn(user, req.Form.Get("keep") != ""); err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	s.renderConflict(writer, req)
+}
+
+func (s *Server) RemoveCombatantHandler(writer http.ResponseWriter, req *http.Request) {
+	room := RoomFromContext(req)
+
+	if err := room.Stats.RemoveCombatant(req.URL.Query().Get("name")); err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	s.conflictChanged(writer, req)
+}
+
+func (s *Server) EndConflictHandler(writer http.ResponseWriter, req *http.Request) {
+	room := RoomFromContext(req)
+
+	if !room.Stats.EndConflict() {
+		s.handleErr(writer, req, notFoundErr("There is no conflict under way."))
+		return
+	}
+
+	s.conflictChanged(writer, req)
+}
+
+// conflictChanged saves and broadcasts a change to the conflict, then renders the user's view of it.
+func (s *Server) conflictChanged(writer http.ResponseWriter, req *http.Request) {
+	if err := RoomFromContext(req).publishConflict(); err != nil {
+		s.handleErr(writer, req, internalErr(err, "failed to save stats"))
+		return
+	}
+
+	s.renderConflict(writer, req)
+}
+
+func (s *Server) renderConflict(writer http.ResponseWriter, req *http.Request) {
+	user := UserFromContext(req)
+	room := RoomFromContext(req)
+
+	data := struct {
+		User       *User
+		Room       *Room
+		Conflict   *Conflict
+		Characters []string
+		NPCs       []NPC
+		TakeTurn   []Combatant
+		EndTurn    bool
+		KeepCost   int
+	}{
+		User:     user,
+		Room:     room,
+		Conflict: room.Stats.Conflict(),
+		KeepCost: keepInitiativeCost,
+	}
+
+	if data.Conflict != nil {
+		data.TakeTurn = data.Conflict.CanTakeTurn(user)
+		data.EndTurn = data.Conflict.CanEndTurn(user)
+	} else if user.IsGameMaster {
+		data.Characters = room.Characters()
+		data.NPCs = room.Stats.Roster()
+	}
+
+	if err := s.Renderer.ExecuteSingle(writer, "conflict", data); err != nil {
+		s.handleErr(writer, req, internalErr(err, "failed to execute conflict template"))
+		return
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import "testing"
+
+func TestConflictTurns(t *testing.T) {
+	kirk := &User{Name: "p", CharacterName: "Kirk"}
+	spock := &User{Name: "q", CharacterName: "Spock"}
+	gm := &User{Name: "GM", IsGameMaster: true}
+
+	take := func(user *User, name string) func(*Stats) error {
+		return func(s *Stats) error {
+			return s.TakeTurn(user, name)
+		}
+	}
+
+	end := func(user *User, keep bool) func(*Stats) error {
+		return func(s *Stats) error {
+			_, err := s.EndTurn(user, keep)
+			return err
+		}
+	}
+
+	remove := func(name string) func(*Stats) error {
+		return func(s *Stats) error {
+			return s.RemoveCombatant(name)
+		}
+	}
+
+	type step struct {
+		do      func(*Stats) error
+		wantErr string
+		round   int
+		turn    ConflictSide
+		current string
+		threat  int
+	}
+
+	tests := []struct {
+		name       string
+		characters []string
+		npcs       []string
+		first      ConflictSide
+		threat     int
+		steps      []step
+	}{
+		{
+			name:       "sides alternate",
+			characters: []string{"Kirk", "Spock"},
+			npcs:       []string{"Klingon"},
+			first:      SidePlayers,
+			steps: []step{
+				{do: take(kirk, "Kirk"), round: 1, turn: SidePlayers, current: "Kirk"},
+				{do: end(kirk, false), round: 1, turn: SideGameMaster},
+				{do: take(gm, "Klingon"), round: 1, turn: SideGameMaster, current: "Klingon"},
+				{do: end(gm, false), round: 1, turn: SidePlayers},
+				{do: take(spock, "Spock"), round: 1, turn: SidePlayers, current: "Spock"},
+				// the players went last, so the Game Master starts the next round
+				{do: end(spock, false), round: 2, turn: SideGameMaster},
+			},
+		},
+		{
+			name:       "a side with nobody left is skipped",
+			characters: []string{"Kirk"},
+			npcs:       []string{"Klingon", "Romulan"},
+			first:      SideGameMaster,
+			steps: []step{
+				{do: take(gm, "Klingon"), round: 1, turn: SideGameMaster, current: "Klingon"},
+				{do: end(gm, false), round: 1, turn: SidePlayers},
+				{do: take(kirk, "Kirk"), round: 1, turn: SidePlayers, current: "Kirk"},
+				{do: end(kirk, false), round: 1, turn: SideGameMaster},
+				{do: take(gm, "Romulan"), round: 1, turn: SideGameMaster, current: "Romulan"},
+				{do: end(gm, false), round: 2, turn: SidePlayers},
+			},
+		},
+		{
+			name:       "players keep the initiative",
+			characters: []string{"Kirk", "Spock"},
+			npcs:       []string{"Klingon"},
+			first:      SidePlayers,
+			steps: []step{
+				{do: take(kirk, "Kirk"), round: 1, turn: SidePlayers, current: "Kirk"},
+				{do: end(kirk, true), round: 1, turn: SidePlayers, threat: 2},
+				{do: take(spock, "Spock"), round: 1, turn: SidePlayers, current: "Spock", threat: 2},
+				{
+					do: end(spock, true), wantErr: "Everyone on Spock's side has already acted this round.",
+					round: 1, turn: SidePlayers, current: "Spock", threat: 2,
+				},
+				{do: end(spock, false), round: 1, turn: SideGameMaster, threat: 2},
+			},
+		},
+		{
+			name:       "Game Master keeps the initiative",
+			characters: []string{"Kirk"},
+			npcs:       []string{"Klingon", "Romulan"},
+			first:      SideGameMaster,
+			threat:     3,
+			steps: []step{
+				{do: take(gm, "Klingon"), round: 1, turn: SideGameMaster, current: "Klingon", threat: 3},
+				{do: end(gm, true), round: 1, turn: SideGameMaster, threat: 1},
+				{do: take(gm, "Romulan"), round: 1, turn: SideGameMaster, current: "Romulan", threat: 1},
+				{do: end(gm, false), round: 1, turn: SidePlayers, threat: 1},
+			},
+		},
+		{
+			name:       "keeping the initiative takes Threat",
+			characters: []string{"Kirk"},
+			npcs:       []string{"Klingon", "Romulan"},
+			first:      SideGameMaster,
+			threat:     1,
+			steps: []step{
+				{do: take(gm, "Klingon"), round: 1, turn: SideGameMaster, current: "Klingon", threat: 1},
+				{
+					do: end(gm, true), wantErr: "Keeping the initiative takes 2 Threat, and there is only 1.",
+					round: 1, turn: SideGameMaster, current: "Klingon", threat: 1,
+				},
+			},
+		},
+		{
+			name:       "only the side whose turn it is acts",
+			characters: []string{"Kirk", "Spock"},
+			npcs:       []string{"Klingon"},
+			first:      SideGameMaster,
+			steps: []step{
+				{do: take(kirk, "Kirk"), wantErr: "It's the Game Master's turn.", round: 1, turn: SideGameMaster},
+				{do: take(kirk, "Klingon"), wantErr: "You can't take Klingon's turn.", round: 1, turn: SideGameMaster},
+				{do: take(gm, "Klingon"), round: 1, turn: SideGameMaster, current: "Klingon"},
+				{
+					do: take(gm, "Klingon"), wantErr: "Klingon is still taking their turn.",
+					round: 1, turn: SideGameMaster, current: "Klingon",
+				},
+				{
+					do: end(kirk, false), wantErr: "Only Klingon's side can end their turn.",
+					round: 1, turn: SideGameMaster, current: "Klingon",
+				},
+				{do: end(gm, false), round: 1, turn: SidePlayers},
+				{do: take(kirk, "Spock"), wantErr: "You can't take Spock's turn.", round: 1, turn: SidePlayers},
+				{do: take(spock, "Spock"), round: 1, turn: SidePlayers, current: "Spock"},
+				{do: end(spock, false), round: 1, turn: SidePlayers},
+				{do: take(spock, "Spock"), wantErr: "Spock has already acted this round.", round: 1, turn: SidePlayers},
+			},
+		},
+		{
+			name:       "removing a combatant passes their turn on",
+			characters: []string{"Kirk", "Spock"},
+			npcs:       []string{"Klingon"},
+			first:      SidePlayers,
+			steps: []step{
+				{do: take(kirk, "Kirk"), round: 1, turn: SidePlayers, current: "Kirk"},
+				{do: remove("Kirk"), round: 1, turn: SideGameMaster},
+				{do: remove("Klingon"), round: 1, turn: SidePlayers},
+				{do: remove("Klingon"), wantErr: "Klingon isn't in the conflict.", round: 1, turn: SidePlayers},
+			},
+		},
+		{
+			name:  "the other side starts if the first has nobody",
+			npcs:  []string{"Klingon"},
+			first: SidePlayers,
+			steps: []step{
+				{do: take(gm, "Klingon"), round: 1, turn: SideGameMaster, current: "Klingon"},
+				{do: end(gm, false), round: 2, turn: SideGameMaster},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stats := &Stats{Threat: test.threat}
+
+			if err := stats.StartConflict(test.characters, test.npcs, test.first); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for i, step := range test.steps {
+				if mismatch := errMismatch(step.do(stats), step.wantErr); mismatch != "" {
+					t.Fatalf("step %d: %s", i, mismatch)
+				}
+
+				conflict := stats.Conflict()
+
+				if conflict.Round != step.round || conflict.Turn != step.turn || conflict.Current != step.current {
+					t.Errorf("step %d: got round %d, %s turn with %q acting, want round %d, %s turn with %q acting",
+						i, conflict.Round, conflict.Turn, conflict.Current, step.round, step.turn, step.current)
+				}
+
+				if stats.Threat != step.threat {
+					t.Errorf("step %d: got Threat %d, want %d", i, stats.Threat, step.threat)
+				}
+			}
+		})
+	}
+}
+
+func TestStartConflictErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		characters []string
+		npcs       []string
+		want       string
+	}{
+		{"nobody", nil, nil, "Choose who is in the conflict."},
+		{"same character twice", []string{"Kirk", "Kirk"}, nil, "Kirk can only join the conflict once."},
+		{"character and NPC share a name", []string{"Kirk"}, []string{"Kirk"}, "Kirk can only join the conflict once."},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stats := &Stats{}
+
+			err := stats.StartConflict(test.characters, test.npcs, SidePlayers)
+			if mismatch := errMismatch(err, test.want); mismatch != "" {
+				t.Fatal(mismatch)
+			}
+
+			if stats.Conflict() != nil {
+				t.Errorf("got a conflict under way, want none")
+			}
+		})
+	}
+
+	stats := &Stats{}
+	if err := stats.StartConflict([]string{"Kirk"}, nil, SidePlayers); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err := stats.StartConflict([]string{"Spock"}, nil, SidePlayers)
+	if mismatch := errMismatch(err, "A conflict is already under way."); mismatch != "" {
+		t.Errorf("starting a second conflict: %s", mismatch)
+	}
+}
@@ -50,7 +50,8 @@ func NewDice(sides, num, critOn, complicationOn int) Dice {
 	return result
 }
 
-func (d Dice) Roll(user *User) Roll {
+// Roll rolls every die. If task is non-nil, the roll is also resolved as a 2d20 task.
+func (d Dice) Roll(user *User, task *TaskOpts) Roll {
 	result := make([]DieResult, len(d))
 
 	for i, die := range d {
@@ -63,13 +64,111 @@ func (d Dice) Roll(user *User) Roll {
 		User:   user,
 	}
 
+	roll.Resolve(task)
+
 	return roll
 }
 
+// TaskOpts describes a 2d20 task. Each die at or below Target is a success, each die at or below Focus is a crit
+// worth two successes, and the task passes if the successes meet the Difficulty.
+type TaskOpts struct {
+	Target     int
+	Focus      int
+	Difficulty int
+}
+
+type TaskResult struct {
+	Target        int  `json:"target"`
+	Difficulty    int  `json:"difficulty"`
+	Successes     int  `json:"successes"`
+	Complications int  `json:"complications"`
+	Passed        bool `json:"passed"`
+	Momentum      int  `json:"momentum"` // excess successes generated by a passed task
+}
+
 type Roll struct {
-	Result DiceResults
-	Time   time.Time
-	User   *User
+	ID         int                  `json:"id"` // position in the room's history, starting at 1
+	Result     DiceResults          `json:"result"`
+	Challenge  ChallengeDiceResults `json:"challenge,omitempty"`
+	Expression string               `json:"expression"`
+	Modifier   int                  `json:"modifier"`
+	Task       *TaskResult          `json:"task,omitempty"`
+	Time       time.Time            `json:"time"`
+	User       *User                `json:"user"`
+	Assists    Assists              `json:"assists,omitempty"` // helpers' dice from a group task
+	Opposed    *OpposedResult       `json:"opposed,omitempty"` // the other side of an opposed task
+
+	ExtendedTask *ExtendedTaskWork `json:"extended_task,omitempty"` // what the roll did for an extended task
+	RerollOf     int               `json:"reroll_of,omitempty"`     // ID of the roll this one rerolled some dice of
+	RerollReason string            `json:"reroll_reason,omitempty"`
+}
+
+// Resolve counts successes against the task, ignoring dropped dice, and adds any assists. A nil task leaves the roll
+// unresolved.
+func (r *Roll) Resolve(task *TaskOpts) {
+	if task == nil {
+		r.Task = nil
+		return
+	}
+
+	result := &TaskResult{
+		Target:     task.Target,
+		Difficulty: task.Difficulty,
+	}
+
+	for i, dieResult := range r.Result {
+		if task.Focus > 0 {
+			dieResult.Crit = dieResult.Value <= task.Focus
+			r.Result[i].Crit = dieResult.Crit
+		}
+
+		if dieResult.Dropped {
+			continue
+		}
+
+		switch {
+		case dieResult.Crit:
+			result.Successes += 2
+		case dieResult.Value <= task.Target:
+			result.Successes++
+		}
+
+		if dieResult.Complication {
+			result.Complications++
+		}
+	}
+
+	// assists only add their successes if the leader succeeds on their own
+	if len(r.Assists) > 0 {
+		successes, complications := r.Assists.Tally()
+
+		if result.Successes > 0 {
+			result.Successes += successes
+		}
+
+		result.Complications += complications
+	}
+
+	result.Passed = result.Successes >= task.Difficulty
+
+	if result.Passed {
+		result.Momentum = result.Successes - task.Difficulty
+	}
+
+	r.Task = result
+}
+
+// Total sums every die that was not dropped and every challenge die, plus the modifier.
+func (r Roll) Total() int {
+	total := r.Modifier + r.Challenge.Total()
+
+	for _, dieResult := range r.Result {
+		if !dieResult.Dropped {
+			total += dieResult.Value
+		}
+	}
+
+	return total
 }
 
 type Rolls []Roll
@@ -77,14 +176,7 @@ type Rolls []Roll
 func (r Rolls) Sort() Rolls {
 	newRolls := make(Rolls, len(r))
 
-	for rollNum := range r {
-		newRoll := Roll{
-			Result: r[rollNum].Result,
-			Time:   r[rollNum].Time,
-			User:   r[rollNum].User,
-		}
-		newRolls[rollNum] = newRoll
-	}
+	copy(newRolls, r)
 
 	sort.Slice(newRolls, func(i, j int) bool {
 		return newRolls[i].Time.After(newRolls[j].Time)
@@ -94,13 +186,46 @@ func (r Rolls) Sort() Rolls {
 }
 
 type DieResult struct {
-	Value        int
-	Crit         bool
-	Complication bool
+	Value        int  `json:"value"`
+	Crit         bool `json:"crit"`
+	Complication bool `json:"complication"`
+	Dropped      bool `json:"dropped"`
+	Rerolled     bool `json:"rerolled,omitempty"`
+	Previous     int  `json:"previous,omitempty"` // the value before the die was rerolled
+
+	Determination bool `json:"determination,omitempty"` // a 1 paid for with Determination rather than rolled
 }
 
 type DiceResults []DieResult
 
+// keep marks every die outside the highest (or lowest) num values as dropped.
+func (d DiceResults) keep(num int, lowest bool) {
+	// a die bought with Determination is always kept, and isn't one of the num
+	var order []int
+
+	for i, dieResult := range d {
+		if !dieResult.Determination {
+			order = append(order, i)
+		}
+	}
+
+	if num >= len(order) {
+		return
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		if lowest {
+			return d[order[i]].Value < d[order[j]].Value
+		}
+
+		return d[order[i]].Value > d[order[j]].Value
+	})
+
+	for _, index := range order[num:] {
+		d[index].Dropped = true
+	}
+}
+
 func (d DiceResults) String() string {
 	result := ""
 
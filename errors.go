@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ErrorKind says what went wrong with a request, which decides the status code it gets.
+type ErrorKind int
+
+const (
+	ErrorKindInternal   ErrorKind = iota // a bug or a broken disk; the details are only logged
+	ErrorKindValidation                  // the request itself was bad
+	ErrorKindAuth                        // the user isn't logged in
+	ErrorKindForbidden                   // the user is logged in but isn't allowed to do this
+	ErrorKindNotFound                    // the thing they asked for doesn't exist
+)
+
+// Status returns the HTTP status code for errors of this kind.
+func (k ErrorKind) Status() int {
+	switch k {
+	case ErrorKindValidation:
+		return http.StatusBadRequest
+	case ErrorKindAuth:
+		return http.StatusUnauthorized
+	case ErrorKindForbidden:
+		return http.StatusForbidden
+	case ErrorKindNotFound:
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// internalErrorMessage is all users get to see about internal errors.
+const internalErrorMessage = "Something went wrong on our end. Try again in a moment."
+
+// HTTPError is an error with a message that is safe to show to users. Err holds the underlying cause, which is logged
+// for internal errors but never sent to the client.
+type HTTPError struct {
+	Kind    ErrorKind
+	Message string
+	Fields  []FieldError // which parts of a validation error's request were wrong
+	Err     error
+}
+
+func (h *HTTPError) Error() string {
+	if h.Err != nil {
+		return fmt.Sprintf("%s: %v", h.Message, h.Err)
+	}
+
+	return h.Message
+}
+
+func (h *HTTPError) Unwrap() error { return h.Err }
+
+// Status returns the HTTP status code for the error.
+func (h *HTTPError) Status() int { return h.Kind.Status() }
+
+// UserMessage returns the message to show users, hiding the details of internal errors.
+func (h *HTTPError) UserMessage() string {
+	if h.Kind == ErrorKindInternal {
+		return internalErrorMessage
+	}
+
+	return h.Message
+}
+
+func validationErr(format string, args ...any) *HTTPError {
+	return &HTTPError{Kind: ErrorKindValidation, Message: fmt.Sprintf(format, args...)}
+}
+
+func authErr(format string, args ...any) *HTTPError {
+	return &HTTPError{Kind: ErrorKindAuth, Message: fmt.Sprintf(format, args...)}
+}
+
+func forbiddenErr(format string, args ...any) *HTTPError {
+	return &HTTPError{Kind: ErrorKindForbidden, Message: fmt.Sprintf(format, args...)}
+}
+
+func notFoundErr(format string, args ...any) *HTTPError {
+	return &HTTPError{Kind: ErrorKindNotFound, Message: fmt.Sprintf(format, args...)}
+}
+
+// internalErr wraps err with a description of what we were doing. Only the logs see either of them.
+func internalErr(err error, format string, args ...any) *HTTPError {
+	return &HTTPError{Kind: ErrorKindInternal, Message: fmt.Sprintf(format, args...), Err: err}
+}
+
+// asHTTPError turns any error into an HTTPError. Parse errors are the player's fault; anything untyped is treated as
+// internal.
+func asHTTPError(err error) *HTTPError {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr
+	}
+
+	var parseErr *ParseError
+	if errors.As(err, &parseErr) {
+		return &HTTPError{Kind: ErrorKindValidation, Message: parseErr.Error(), Err: parseErr}
+	}
+
+	return internalErr(err, "unexpected error")
+}
+
+// handleErr answers a failed request in whatever form the client understands: JSON for API clients, a fragment for
+// htmx to swap into the page, or a full error page for plain form posts.
+func (s *Server) handleErr(writer http.ResponseWriter, req *http.Request, err error) {
+	httpErr := asHTTPError(err)
+
+	if httpErr.Kind == ErrorKindInternal {
+		fmt.Fprintf(os.Stderr, "%s %s: %v\n", req.Method, req.URL.Path, httpErr)
+	}
+
+	var parseErr *ParseError
+
+	errors.As(httpErr, &parseErr)
+
+	if strings.HasPrefix(req.URL.Path, "/api/") || wantsJSON(req) {
+		apiErr := APIError{
+			Status:  httpErr.Status(),
+			Message: httpErr.UserMessage(),
+		}
+
+		if parseErr != nil {
+			apiErr.Position = &parseErr.Position
+		}
+
+		apiErr.Fields = httpErr.Fields
+
+		s.apiJSON(writer, apiErr.Status, apiErr)
+
+		return
+	}
+
+	data := struct {
+		Status     int
+		StatusText string
+		Message    string
+		Fields     []FieldError
+		ParseError *ParseError
+	}{
+		Status:     httpErr.Status(),
+		StatusText: http.StatusText(httpErr.Status()),
+		Message:    httpErr.UserMessage(),
+		Fields:     httpErr.Fields,
+		ParseError: parseErr,
+	}
+
+	// render first, so a broken template still gets the right status code
+	var (
+		buf       bytes.Buffer
+		renderErr error
+	)
+
+	if req.Header.Get("HX-Request") != "" {
+		// index.js swaps error responses into the form's error area, or the page's if it has none
+		writer.Header().Set("HX-Reswap", "innerHTML")
+		renderErr = s.Renderer.ExecuteSingle(&buf, "error", data)
+	} else {
+		renderErr = s.Renderer.ExecutePage(&buf, "error", data)
+	}
+
+	if renderErr != nil {
+		fmt.Fprintf(os.Stderr, "failed to render error template: %v\noriginal error: %v\n", renderErr, httpErr)
+		http.Error(writer, data.Message, data.Status)
+
+		return
+	}
+
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writer.WriteHeader(data.Status)
+
+	if _, err := buf.WriteTo(writer); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write error response: %v\noriginal error: %v\n", err, httpErr)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import "fmt"
+
+// errMismatch describes how err differs from an error users see as want, where an empty want means no error. It
+// returns "" if they match.
+func errMismatch(err error, want string) string {
+	switch {
+	case want == "" && err != nil:
+		return fmt.Sprintf("unexpected error: %v", err)
+	case want != "" && err == nil:
+		return fmt.Sprintf("got no error, want %q", want)
+	case want != "" && asHTTPError(err).UserMessage() != want:
+		return fmt.Sprintf("got error %q, want %q", asHTTPError(err).UserMessage(), want)
+	}
+
+	return ""
+}
+
+// errFields returns the fields a validation error blames, or nil if there aren't any.
+func errFields(err error) []string {
+	var fields []string
+
+	for _, field := range asHTTPError(err).Fields {
+		fields = append(fields, field.Field)
+	}
+
+	return fields
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const eventLogSize = 128
+
+// eventLog is a ring buffer of a room's most recent events. Event IDs look like "{epoch}-{sequence}" where the epoch is
+// when the log was created, so IDs from before a restart are never mistaken for current ones.
+type eventLog struct {
+	epoch  int64
+	nextID uint64
+	events []EventMessage
+}
+
+func newEventLog() *eventLog {
+	return &eventLog{
+		epoch:  time.Now().UnixNano(),
+		nextID: 1,
+		events: make([]EventMessage, 0, eventLogSize),
+	}
+}
+
+// add assigns the message the next ID and remembers it, forgetting the oldest event if the log is full. The caller must
+// hold the room's client lock.
+func (e *eventLog) add(message EventMessage) EventMessage {
+	message.ID = fmt.Sprintf("%d-%d", e.epoch, e.nextID)
+	e.nextID++
+
+	if len(e.events) == eventLogSize {
+		copy(e.events, e.events[1:])
+		e.events = e.events[:eventLogSize-1]
+	}
+
+	e.events = append(e.events, message)
+
+	return message
+}
+
+// lastID returns the ID of the most recent event, which lets a client that resynced replay from there next time. The
+// caller must hold the room's client lock.
+func (e *eventLog) lastID() string {
+	return fmt.Sprintf("%d-%d", e.epoch, e.nextID-1)
+}
+
+// since returns the events after lastID. It returns false if they can't all be replayed, because lastID is from before
+// a restart or so old that it has already been forgotten. The caller must hold the room's client lock.
+func (e *eventLog) since(lastID string) ([]EventMessage, bool) {
+	epochPart, seqPart, found := strings.Cut(lastID, "-")
+	if !found {
+		return nil, false
+	}
+
+	epoch, err := strconv.ParseInt(epochPart, 10, 64)
+	if err != nil || epoch != e.epoch {
+		return nil, false
+	}
+
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil || seq >= e.nextID {
+		return nil, false
+	}
+
+	missed := e.nextID - 1 - seq
+	if missed > uint64(len(e.events)) {
+		return nil, false
+	}
+
+	result := make([]EventMessage, missed)
+	copy(result, e.events[uint64(len(e.events))-missed:])
+
+	return result, true
+}
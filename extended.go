@@ -0,0 +1,270 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ExtendedTask is a task that takes several rolls to finish. Challenge dice rolled for it add their total, less the
+// Resistance, to Progress, and every Effect rolled is a Breakthrough. It's complete once Progress fills the Work track
+// and there are at least Magnitude Breakthroughs.
+type ExtendedTask struct {
+	ID            int    `json:"id"`
+	Name          string `json:"name"`
+	Work          int    `json:"work"`
+	Progress      int    `json:"progress"`
+	Magnitude     int    `json:"magnitude"`
+	Resistance    int    `json:"resistance"`
+	Breakthroughs int    `json:"breakthroughs"`
+}
+
+func (e ExtendedTask) Complete() bool {
+	return e.Progress >= e.Work && e.Breakthroughs >= e.Magnitude
+}
+
+// ExtendedTaskWork is what one roll did for an extended task.
+type ExtendedTaskWork struct {
+	ID            int    `json:"id"`
+	Name          string `json:"name"`
+	Work          int    `json:"work"`
+	Breakthroughs int    `json:"breakthroughs"`
+	Complete      bool   `json:"complete"` // the roll finished the task
+}
+
+func (s *Stats) AddExtendedTask(task ExtendedTask) ExtendedTask {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	task.ID = 1
+	for _, existing := range s.ExtendedTasks {
+		task.ID = max(task.ID, existing.ID+1)
+	}
+
+	s.ExtendedTasks = append(s.ExtendedTasks, task)
+
+	return task
+}
+
+// RemoveExtendedTask reports whether there was a task with id to remove.
+func (s *Stats) RemoveExtendedTask(id int) bool {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	before := len(s.ExtendedTasks)
+	s.ExtendedTasks = slices.DeleteFunc(s.ExtendedTasks, func(task ExtendedTask) bool { return task.ID == id })
+
+	return len(s.ExtendedTasks) != before
+}
+
+func (s *Stats) ExtendedTask(id int) (ExtendedTask, bool) {
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+
+	for _, task := range s.ExtendedTasks {
+		if task.ID == id {
+			return task, true
+		}
+	}
+
+	return ExtendedTask{}, false
+}
+
+// OpenExtendedTasks returns the extended tasks that aren't complete yet.
+func (s *Stats) OpenExtendedTasks() []ExtendedTask {
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+
+	var tasks []ExtendedTask
+
+	for _, task := range s.ExtendedTasks {
+		if !task.Complete() {
+			tasks = append(tasks, task)
+		}
+	}
+
+	return tasks
+}
+
+// WorkFor works out what roll does for extended task id, without changing the task; see ApplyWork. Only challenge
+// dice do Work; other rolls are just tagged. It returns nil if the task is gone.
+func (s *Stats) WorkFor(id int, roll Roll) *ExtendedTaskWork {
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+
+	index := slices.IndexFunc(s.ExtendedTasks, func(task ExtendedTask) bool { return task.ID == id })
+	if index < 0 {
+		return nil
+	}
+
+	task := s.ExtendedTasks[index]
+	result := &ExtendedTaskWork{ID: task.ID, Name: task.Name}
+
+	if len(roll.Challenge) > 0 && !task.Complete() {
+		result.Work = max(roll.Total()-task.Resistance, 0)
+		result.Breakthroughs = roll.Challenge.Effects()
+
+		task.Progress = min(task.Progress+result.Work, task.Work)
+		task.Breakthroughs += result.Breakthroughs
+		result.Complete = task.Complete()
+	}
+
+	return result
+}
+
+// ApplyWork adds the Work and Breakthroughs of a roll that's in the history to its extended task, if it's still there.
+func (s *Stats) ApplyWork(work *ExtendedTaskWork) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	index := slices.IndexFunc(s.ExtendedTasks, func(task ExtendedTask) bool { return task.ID == work.ID })
+	if index < 0 {
+		return
+	}
+
+	task := &s.ExtendedTasks[index]
+	task.Progress = min(task.Progress+work.Work, task.Work)
+	task.Breakthroughs += work.Breakthroughs
+}
+
+// checkExtendedTask makes sure a roll can be tagged to extended task id.
+func (r *Room) checkExtendedTask(id int) error {
+	task, ok := r.Stats.ExtendedTask(id)
+
+	switch {
+	case !ok:
+		return notFoundErr("There is no extended task #%d.", id)
+	case task.Complete():
+		return validationErr("%s is already complete.", task.Name)
+	}
+
+	return nil
+}
+
+func (s *Server) ExtendedTasksHandler(writer http.ResponseWriter, req *http.Request) {
+	s.renderExtendedTasksEditor(writer, req)
+}
+
+// ExtendedTaskSelectHandler renders the list of open extended tasks that rolls can be tagged with, keeping the one
+// already chosen selected.
+func (s *Server) ExtendedTaskSelectHandler(writer http.ResponseWriter, req *http.Request) {
+	room := RoomFromContext(req)
+
+	// anything that isn't a task ID selects nothing
+	selected, _ := strconv.Atoi(req.URL.Query().Get("extended-task"))
+
+	data := struct {
+		Room     *Room
+		Tasks    []ExtendedTask
+		Selected int
+	}{
+		Room:     room,
+		Tasks:    room.Stats.OpenExtendedTasks(),
+		Selected: selected,
+	}
+
+	if err := s.Renderer.ExecuteSingle(writer, "extended_task_select", data); err != nil {
+		s.handleErr(writer, req, internalErr(err, "failed to execute extended task select template"))
+		return
+	}
+}
+
+func (s *Server) AddExtendedTaskHandler(writer http.ResponseWriter, req *http.Request) {
+	room := RoomFromContext(req)
+
+	task, err := extendedTaskFromForm(req)
+	if err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	room.Stats.AddExtendedTask(task)
+
+	if err := room.saveStats(); err != nil {
+		s.handleErr(writer, req, internalErr(err, "failed to save stats"))
+		return
+	}
+
+	room.NotifyClients(EventTypeStats)
+
+	s.renderExtendedTasksEditor(writer, req)
+}
+
+func (s *Server) RemoveExtendedTaskHandler(writer http.ResponseWriter, req *http.Request) {
+	room := RoomFromContext(req)
+
+	id, err := strconv.Atoi(req.PathValue("id"))
+	if err != nil {
+		s.handleErr(writer, req, validationErr("Extended task must be a number."))
+		return
+	}
+
+	if !room.Stats.RemoveExtendedTask(id) {
+		s.handleErr(writer, req, notFoundErr("There is no extended task #%d.", id))
+		return
+	}
+
+	if err := room.saveStats(); err != nil {
+		s.handleErr(writer, req, internalErr(err, "failed to save stats"))
+		return
+	}
+
+	room.NotifyClients(EventTypeStats)
+
+	s.renderExtendedTasksEditor(writer, req)
+}
+
+func extendedTaskFromForm(req *http.Request) (ExtendedTask, error) {
+	if err := req.ParseForm(); err != nil {
+		return ExtendedTask{}, validationErr("Failed to parse form: %v", err)
+	}
+
+	task := ExtendedTask{Name: strings.TrimSpace(req.Form.Get("name"))}
+	if task.Name == "" {
+		return task, validationErr("Name the extended task.")
+	}
+
+	numbers := []struct {
+		field string
+		label string
+		value *int
+		min   int
+	}{
+		{"work", "Work", &task.Work, 1},
+		{"magnitude", "Magnitude", &task.Magnitude, 0},
+		{"resistance", "Resistance", &task.Resistance, 0},
+	}
+
+	for _, number := range numbers {
+		value, err := strconv.Atoi(req.Form.Get(number.field))
+		if err != nil || value < number.min {
+			return task, validationErr("%s must be a whole number of at least %d.", number.label, number.min)
+		}
+
+		*number.value = value
+	}
+
+	return task, nil
+}
+
+func (s *Server) renderExtendedTasksEditor(writer http.ResponseWriter, req *http.Request) {
+	room := RoomFromContext(req)
+
+	room.Stats.Mutex.RLock()
+	tasks := slices.Clone(room.Stats.ExtendedTasks)
+	room.Stats.Mutex.RUnlock()
+
+	data := struct {
+		Room  *Room
+		Tasks []ExtendedTask
+	}{
+		Room:  room,
+		Tasks: tasks,
+	}
+
+	if err := s.Renderer.ExecuteSingle(writer, "extended_tasks_editor", data); err != nil {
+		s.handleErr(writer, req, internalErr(err, "failed to execute extended tasks editor template"))
+		return
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestWorkFor(t *testing.T) {
+	challenge := func(faces ...int) ChallengeDiceResults {
+		results := make(ChallengeDiceResults, len(faces))
+		for i, face := range faces {
+			results[i] = newChallengeDieResult(face)
+		}
+
+		return results
+	}
+
+	tests := []struct {
+		name string
+		task ExtendedTask
+		roll Roll
+		want ExtendedTaskWork
+	}{
+		{
+			name: "Work is the total less the Resistance",
+			task: ExtendedTask{Work: 6, Magnitude: 1, Resistance: 1},
+			roll: Roll{Challenge: challenge(1, 2, 5)},
+			want: ExtendedTaskWork{Work: 3, Breakthroughs: 1},
+		},
+		{
+			name: "modifiers count",
+			task: ExtendedTask{Work: 6, Magnitude: 1, Resistance: 1},
+			roll: Roll{Challenge: challenge(2), Modifier: 2},
+			want: ExtendedTaskWork{Work: 3},
+		},
+		{
+			name: "Resistance can't make Work negative",
+			task: ExtendedTask{Work: 6, Magnitude: 1, Resistance: 2},
+			roll: Roll{Challenge: challenge(3, 6)},
+			want: ExtendedTaskWork{Breakthroughs: 1},
+		},
+		{
+			name: "finishing the task",
+			task: ExtendedTask{Work: 6, Magnitude: 1, Resistance: 1, Progress: 4},
+			roll: Roll{Challenge: challenge(2, 6)},
+			want: ExtendedTaskWork{Work: 2, Breakthroughs: 1, Complete: true},
+		},
+		{
+			name: "a full Work track still needs Breakthroughs",
+			task: ExtendedTask{Work: 6, Magnitude: 2, Progress: 6, Breakthroughs: 1},
+			roll: Roll{Challenge: challenge(1)},
+			want: ExtendedTaskWork{Work: 1},
+		},
+		{
+			name: "d20 rolls are only tagged",
+			task: ExtendedTask{Work: 6, Magnitude: 1},
+			roll: Roll{Result: DiceResults{{Value: 3}, {Value: 5}}},
+			want: ExtendedTaskWork{},
+		},
+		{
+			name: "a complete task takes no more Work",
+			task: ExtendedTask{Work: 6, Magnitude: 1, Progress: 6, Breakthroughs: 1},
+			roll: Roll{Challenge: challenge(2, 2)},
+			want: ExtendedTaskWork{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stats := &Stats{}
+			task := stats.AddExtendedTask(test.task)
+
+			test.want.ID = task.ID
+
+			work := stats.WorkFor(task.ID, test.roll)
+			if work == nil || !reflect.DeepEqual(*work, test.want) {
+				t.Fatalf("got %+v, want %+v", work, test.want)
+			}
+
+			// working it out changes nothing until it's applied
+			if unchanged, _ := stats.ExtendedTask(task.ID); unchanged != task {
+				t.Fatalf("got %+v after WorkFor, want %+v", unchanged, task)
+			}
+
+			stats.ApplyWork(work)
+
+			applied, _ := stats.ExtendedTask(task.ID)
+			if applied.Complete() != (test.want.Complete || test.task.Complete()) {
+				t.Errorf("got %+v after applying the work, want complete %t", applied, test.want.Complete)
+			}
+
+			if applied.Progress > applied.Work {
+				t.Errorf("got Progress %d past the Work track of %d", applied.Progress, applied.Work)
+			}
+		})
+	}
+
+	if work := (&Stats{}).WorkFor(1, Roll{}); work != nil {
+		t.Errorf("got %+v for a task that doesn't exist, want nil", work)
+	}
+}
+
+// failingStore can't save rolls.
+type failingStore struct {
+	MemoryStore
+}
+
+func (f *failingStore) AppendRoll(Roll) error { return errors.New("disk full") }
+
+func TestExtendedTaskOnlyMovesOnceSaved(t *testing.T) {
+	renderer, err := NewTemplateRenderer()
+	if err != nil {
+		t.Fatalf("failed to set up templates: %v", err)
+	}
+
+	room, err := NewRoom(&RoomConfig{Name: "test"}, &failingStore{}, renderer)
+	if err != nil {
+		t.Fatalf("failed to set up room: %v", err)
+	}
+
+	task := room.Stats.AddExtendedTask(ExtendedTask{Name: "Repair the warp core", Work: 10, Magnitude: 1})
+
+	expr := &DiceExpression{Challenge: true, Count: 4, Sides: 6, ExtendedTask: task.ID}
+	if _, err := room.Roll(&User{Name: "p"}, expr, false); err == nil {
+		t.Fatalf("got no error saving the roll")
+	}
+
+	if unchanged, _ := room.Stats.ExtendedTask(task.ID); unchanged != task {
+		t.Errorf("got %+v after a roll that wasn't saved, want %+v", unchanged, task)
+	}
+}
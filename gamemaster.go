@@ -0,0 +1,40 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// gameMasterClaim binds a Game Master session to the room, the user's name and the room's current password hash, so
+// changing the password revokes every existing Game Master session.
+func (r *Room) gameMasterClaim(user *User) string {
+	mac := hmac.New(sha256.New, []byte(r.Config.GameMasterPasswordHash))
+	mac.Write([]byte("game-master|" + r.Name() + "|" + user.Name))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkGameMasterPassword compares password against the room's bcrypt hash.
+func (r *Room) checkGameMasterPassword(password string) bool {
+	err := bcrypt.CompareHashAndPassword([]byte(r.Config.GameMasterPasswordHash), []byte(password))
+
+	return err == nil
+}
+
+// isGameMaster verifies the user's Game Master claim for their room rather than trusting the IsGameMaster flag on its
+// own.
+func (s *Server) isGameMaster(user *User) bool {
+	if user == nil || !user.IsGameMaster || user.GameMasterClaim == "" {
+		return false
+	}
+
+	room, ok := s.Rooms[user.Room]
+	if !ok {
+		return false
+	}
+
+	return hmac.Equal([]byte(user.GameMasterClaim), []byte(room.gameMasterClaim(user)))
+}
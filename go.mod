@@ -2,11 +2,16 @@ module github.com/cneill/d20
 
 go 1.22.4
 
+require (
+	github.com/gorilla/handlers v1.5.2
+	github.com/urfave/cli/v2 v2.27.3
+	golang.org/x/crypto v0.33.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
 require (
 	github.com/cpuguy83/go-md2man/v2 v2.0.4 // indirect
 	github.com/felixge/httpsnoop v1.0.3 // indirect
-	github.com/gorilla/handlers v1.5.2 // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
-	github.com/urfave/cli/v2 v2.27.3 // indirect
 	github.com/xrash/smetrics v0.0.0-20240521201337-686a1a2994c1 // indirect
 )
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Assist is one helper's d20 in a group task, rolled against their own target number.
+type Assist struct {
+	User   *User     `json:"user"`
+	Target int       `json:"target"`
+	Result DieResult `json:"result"`
+}
+
+// Successes counts the assist die like a task die: a crit is worth two successes.
+func (a Assist) Successes() int {
+	switch {
+	case a.Result.Crit:
+		return 2
+	case a.Result.Value <= a.Target:
+		return 1
+	}
+
+	return 0
+}
+
+type Assists []Assist
+
+// Tally returns the successes and complications of every assist die.
+func (a Assists) Tally() (int, int) {
+	successes, complications := 0, 0
+
+	for _, assist := range a {
+		successes += assist.Successes()
+
+		if assist.Result.Complication {
+			complications++
+		}
+	}
+
+	return successes, complications
+}
+
+// GroupTask is a task that other characters can assist before its leader rolls. Each room has at most one open.
+type GroupTask struct {
+	Leader      *User
+	Expression  *DiceExpression
+	AddMomentum bool
+	Assists     Assists
+	Opened      time.Time
+}
+
+// GroupTask returns a copy of the open group task, or nil if there isn't one.
+func (r *Room) GroupTask() *GroupTask {
+	r.groupTaskMutex.Lock()
+	defer r.groupTaskMutex.Unlock()
+
+	if r.groupTask == nil {
+		return nil
+	}
+
+	task := *r.groupTask
+	task.Assists = slices.Clone(task.Assists)
+
+	return &task
+}
+
+// OpenGroupTask makes user the leader of a new group task for expr, which must have a target number.
+func (r *Room) OpenGroupTask(user *User, expr *DiceExpression, addMomentum bool) error {
+	if expr.Challenge || expr.Target == 0 {
+		return validationErr("A group task needs a target number.")
+	}
+
+	r.groupTaskMutex.Lock()
+
+	if r.groupTask != nil {
+		leader := r.groupTask.Leader.CharacterName
+		r.groupTaskMutex.Unlock()
+
+		return validationErr("%s is already leading a group task.", leader)
+	}
+
+	r.groupTask = &GroupTask{
+		Leader:      user,
+		Expression:  expr,
+		AddMomentum: addMomentum,
+		Opened:      time.Now(),
+	}
+
+	r.groupTaskMutex.Unlock()
+
+	r.NotifyGroupTask(user.CharacterName + " is leading a group task. Assist them before they roll!")
+
+	return nil
+}
+
+// AssistGroupTask rolls user's assist die for the open group task.
+func (r *Room) AssistGroupTask(user *User, target, focus int) (Assist, error) {
+	r.groupTaskMutex.Lock()
+
+	task := r.groupTask
+
+	switch {
+	case task == nil:
+		r.groupTaskMutex.Unlock()
+		return Assist{}, notFoundErr("There is no group task to assist.")
+	case task.Leader.Same(user):
+		r.groupTaskMutex.Unlock()
+		return Assist{}, validationErr("You can't assist your own task.")
+	case slices.ContainsFunc(task.Assists, func(assist Assist) bool { return assist.User.Same(user) }):
+		r.groupTaskMutex.Unlock()
+		return Assist{}, validationErr("You've already assisted this task.")
+	}
+
+	assist := Assist{
+		User:   user,
+		Target: target,
+		Result: Die{Sides: 20, CritOn: focus, ComplicationOn: 20}.Roll(),
+	}
+
+	task.Assists = append(task.Assists, assist)
+	leader := task.Leader.CharacterName
+
+	r.groupTaskMutex.Unlock()
+
+	r.NotifyGroupTask(user.CharacterName + " assisted " + leader + ".")
+
+	return assist, nil
+}
+
+// FinishGroupTask rolls the leader's dice and adds them to the history as one roll with every assist.
+func (r *Room) FinishGroupTask(user *User) (Roll, error) {
+	r.groupTaskMutex.Lock()
+
+	task := r.groupTask
+
+	switch {
+	case task == nil:
+		r.groupTaskMutex.Unlock()
+		return Roll{}, notFoundErr("There is no group task to roll.")
+	case !task.Leader.Same(user):
+		r.groupTaskMutex.Unlock()
+		return Roll{}, forbiddenErr("Only %s can roll this group task.", task.Leader.CharacterName)
+	}
+
+	r.groupTask = nil
+	r.groupTaskMutex.Unlock()
+
+	roll := task.Expression.Roll(user)
+	roll.Assists = task.Assists
+	roll.Resolve(task.Expression.Task())
+
+	roll, err := r.commitRoll(user, task.Expression, roll, task.AddMomentum)
+	if err != nil {
+		return roll, err
+	}
+
+	r.NotifyGroupTask(user.CharacterName + " rolled their group task.")
+
+	return roll, nil
+}
+
+// CancelGroupTask closes the open group task without rolling. Only its leader and the Game Master can cancel it.
+func (r *Room) CancelGroupTask(user *User) error {
+	r.groupTaskMutex.Lock()
+
+	task := r.groupTask
+
+	switch {
+	case task == nil:
+		r.groupTaskMutex.Unlock()
+		return notFoundErr("There is no group task to cancel.")
+	case !user.IsGameMaster && !task.Leader.Same(user):
+		r.groupTaskMutex.Unlock()
+		return forbiddenErr("Only %s or the Game Master can cancel this group task.", task.Leader.CharacterName)
+	}
+
+	r.groupTask = nil
+	r.groupTaskMutex.Unlock()
+
+	r.NotifyGroupTask(task.Leader.CharacterName + "'s group task was cancelled.")
+
+	return nil
+}
+
+// NotifyGroupTask tells every client that the group task changed, so they can reload their view of it.
+func (r *Room) NotifyGroupTask(message string) {
+	var buf bytes.Buffer
+
+	if err := r.renderer.ExecuteSingle(&buf, "group_task_notice", message); err != nil {
+		log.Printf("Error rendering group task notice: %v", err)
+		return
+	}
+
+	r.broadcast(EventMessage{
+		EventType: EventTypeGroupTask,
+		Data:      buf.Bytes(),
+	})
+}
+
+func (s *Server) GroupTaskHandler(writer http.ResponseWriter, req *http.Request) {
+	s.renderGroupTask(writer, req)
+}
+
+func (s *Server) OpenGroupTaskHandler(writer http.ResponseWriter, req *http.Request) {
+	user := UserFromContext(req)
+	room := RoomFromContext(req)
+
+	if err := req.ParseForm(); err != nil {
+		s.handleErr(writer, req, validationErr("Failed to parse form: %v", err))
+		return
+	}
+
+	expr, err := room.characterDice(user, req.Form)
+	if err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	if err := s.Opts.Config.DiceLimits.Check(expr); err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	if err := room.OpenGroupTask(user, expr, req.Form.Get("add-momentum") != ""); err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	s.renderGroupTask(writer, req)
+}
+
+func (s *Server) AssistGroupTaskHandler(writer http.ResponseWriter, req *http.Request) {
+	user := UserFromContext(req)
+	room := RoomFromContext(req)
+
+	if err := req.ParseForm(); err != nil {
+		s.handleErr(writer, req, validationErr("Failed to parse form: %v", err))
+		return
+	}
+
+	target, err := strconv.Atoi(req.Form.Get("target"))
+	if err != nil || target < 1 || target > 20 {
+		s.handleErr(writer, req, validationErr("Target number must be between 1 and 20."))
+		return
+	}
+
+	focus := 1
+
+	if rawFocus := req.Form.Get("crit-on"); rawFocus != "" {
+		focus, err = strconv.Atoi(rawFocus)
+		if err != nil || focus < 1 || focus > target {
+			s.handleErr(writer, req, validationErr("'Crit on' must be between 1 and your target number."))
+			return
+		}
+	}
+
+	if _, err := room.AssistGroupTask(user, target, focus); err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	s.renderGroupTask(writer, req)
+}
+
+func (s *Server) RollGroupTaskHandler(writer http.ResponseWriter, req *http.Request) {
+	user := UserFromContext(req)
+	room := RoomFromContext(req)
+
+	if _, err := room.FinishGroupTask(user); err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	s.renderGroupTask(writer, req)
+}
+
+func (s *Server) CancelGroupTaskHandler(writer http.ResponseWriter, req *http.Request) {
+	user := UserFromContext(req)
+	room := RoomFromContext(req)
+
+	if err := room.CancelGroupTask(user); err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	s.renderGroupTask(writer, req)
+}
+
+func (s *Server) renderGroupTask(writer http.ResponseWriter, req *http.Request) {
+	user := UserFromContext(req)
+	room := RoomFromContext(req)
+	task := room.GroupTask()
+
+	data := struct {
+		User     *User
+		Room     *Room
+		Task     *GroupTask
+		Leading  bool
+		Assisted bool
+	}{
+		User: user,
+		Room: room,
+		Task: task,
+	}
+
+	if task != nil {
+		data.Leading = task.Leader.Same(user)
+		data.Assisted = slices.ContainsFunc(task.Assists, func(assist Assist) bool { return assist.User.Same(user) })
+	}
+
+	if err := s.Renderer.ExecuteSingle(writer, "group_task", data); err != nil {
+		s.handleErr(writer, req, internalErr(err, "failed to execute group task template"))
+		return
+	}
+}
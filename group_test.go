@@ -0,0 +1,115 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGroupTaskResolve(t *testing.T) {
+	assist := func(value, target int, crit, complication bool) Assist {
+		return Assist{Target: target, Result: DieResult{Value: value, Crit: crit, Complication: complication}}
+	}
+
+	tests := []struct {
+		name    string
+		result  DiceResults
+		assists Assists
+		want    TaskResult
+	}{
+		{
+			name:    "assists add to a success",
+			result:  DiceResults{{Value: 4}, {Value: 15}},
+			assists: Assists{assist(8, 9, false, false), assist(1, 11, true, false)},
+			want:    TaskResult{Target: 10, Difficulty: 2, Successes: 4, Passed: true, Momentum: 2},
+		},
+		{
+			name:    "each helper rolls against their own target",
+			result:  DiceResults{{Value: 4}, {Value: 15}},
+			assists: Assists{assist(10, 9, false, false), assist(10, 11, false, false)},
+			want:    TaskResult{Target: 10, Difficulty: 2, Successes: 2, Passed: true},
+		},
+		{
+			name:    "assists don't count if the leader fails",
+			result:  DiceResults{{Value: 14}, {Value: 15}},
+			assists: Assists{assist(1, 9, true, false), assist(3, 11, false, false)},
+			want:    TaskResult{Target: 10, Difficulty: 2},
+		},
+		{
+			name:    "complications always count",
+			result:  DiceResults{{Value: 14}, {Value: 15}},
+			assists: Assists{assist(20, 9, false, true)},
+			want:    TaskResult{Target: 10, Difficulty: 2, Complications: 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			roll := Roll{Result: test.result, Assists: test.assists}
+			roll.Resolve(&TaskOpts{Target: 10, Difficulty: 2})
+
+			if !reflect.DeepEqual(*roll.Task, test.want) {
+				t.Errorf("got %+v, want %+v", *roll.Task, test.want)
+			}
+		})
+	}
+}
+
+func TestGroupTask(t *testing.T) {
+	room := newTestRoom(t)
+	kirk := &User{Name: "p", CharacterName: "Kirk"}
+	spock := &User{Name: "q", CharacterName: "Spock"}
+	mccoy := &User{Name: "r", CharacterName: "McCoy"}
+	gm := &User{Name: "GM", IsGameMaster: true}
+
+	want := func(err error, message string) {
+		t.Helper()
+
+		if mismatch := errMismatch(err, message); mismatch != "" {
+			t.Fatal(mismatch)
+		}
+	}
+
+	_, err := room.AssistGroupTask(spock, 10, 1)
+	want(err, "There is no group task to assist.")
+	want(room.OpenGroupTask(kirk, &DiceExpression{Count: 2, Sides: 20}, false), "A group task needs a target number.")
+
+	expr := &DiceExpression{Count: 2, Sides: 20, Target: 11, Difficulty: 1}
+
+	want(room.OpenGroupTask(kirk, expr, false), "")
+	want(room.OpenGroupTask(spock, expr, false), "Kirk is already leading a group task.")
+
+	_, err = room.AssistGroupTask(kirk, 10, 1)
+	want(err, "You can't assist your own task.")
+
+	_, err = room.AssistGroupTask(spock, 9, 2)
+	want(err, "")
+
+	_, err = room.AssistGroupTask(spock, 9, 2)
+	want(err, "You've already assisted this task.")
+
+	_, err = room.AssistGroupTask(mccoy, 12, 1)
+	want(err, "")
+
+	_, err = room.FinishGroupTask(spock)
+	want(err, "Only Kirk can roll this group task.")
+	want(room.CancelGroupTask(spock), "Only Kirk or the Game Master can cancel this group task.")
+
+	roll, err := room.FinishGroupTask(kirk)
+	want(err, "")
+
+	if len(roll.Assists) != 2 || roll.Assists[0].Target != 9 || roll.Assists[1].Target != 12 || roll.Task == nil {
+		t.Errorf("got %+v, want Kirk's task with Spock's and McCoy's assists", roll)
+	}
+
+	if history := room.History(); len(history) != 1 || history[0].ID != roll.ID {
+		t.Errorf("got %d rolls in the history, want the group task's", len(history))
+	}
+
+	if room.GroupTask() != nil {
+		t.Errorf("got a group task still open after rolling it")
+	}
+
+	want(room.CancelGroupTask(gm), "There is no group task to cancel.")
+	want(room.OpenGroupTask(spock, expr, false), "")
+	want(room.CancelGroupTask(gm), "")
+}
@@ -3,7 +3,7 @@ package main
 import (
 	"fmt"
 	"net/http"
-	"os"
+	"net/url"
 	"strconv"
 	"strings"
 )
@@ -11,20 +11,20 @@ import (
 func (s *Server) IndexHandler(writer http.ResponseWriter, req *http.Request) {
 	user := UserFromContext(req)
 	if user != nil {
-		http.Redirect(writer, req, "/dice", http.StatusSeeOther)
+		s.UserRoomHandler(writer, req)
 		return
 	}
 
 	switch req.Method {
 	case http.MethodGet:
 		if err := s.Renderer.ExecutePage(writer, "index", struct{}{}); err != nil {
-			s.doErr(writer, fmt.Sprintf("Failed to execute index template: %s", err))
+			s.handleErr(writer, req, internalErr(err, "failed to execute index template"))
 			return
 		}
 
 	case http.MethodPost:
 		if err := req.ParseForm(); err != nil {
-			s.doErr(writer, fmt.Sprintf("Failed to parse form: %v", err))
+			s.handleErr(writer, req, validationErr("Failed to parse form: %v", err))
 			return
 		}
 
@@ -33,42 +33,115 @@ func (s *Server) IndexHandler(writer http.ResponseWriter, req *http.Request) {
 		partyKey := req.Form.Get("party-key")
 
 		if name == "" {
-			s.doErr(writer, "You must enter a name.")
+			s.handleErr(writer, req, validationErr("You must enter a name."))
 			return
 		}
 
-		if partyKey != s.Opts.Config.PartyKey {
-			s.doErr(writer, "Invalid party key")
+		room := s.roomFromPartyKey(partyKey)
+		if room == nil {
+			s.handleErr(writer, req, authErr("Invalid party key"))
 			return
 		}
 
-		var ipAddr string
-
-		if forwarded := req.Header.Get("X-Forwarded-For"); forwarded != "" {
-			ipAddr = maskIP(forwarded)
-		} else {
-			ipAddr = maskIP(req.RemoteAddr)
-		}
-
 		user := &User{
 			Name:          name,
 			CharacterName: characterName,
-			IsGameMaster:  characterName == s.Opts.Config.GameMasterName,
-			IPAddress:     ipAddr,
+			Room:          room.Name(),
+			IPAddress:     requestIP(req),
 		}
 
-		dataCookie, err := user.DataCookie(s.secretKey)
-		if err != nil {
-			s.doErr(writer, fmt.Sprintf("failed to save cookie: %v", err))
+		s.login(writer, req, user)
+	default:
+		s.handleErr(writer, req, validationErr("Invalid HTTP method: %q", req.Method))
+		return
+	}
+}
+
+func (s *Server) GameMasterLoginHandler(writer http.ResponseWriter, req *http.Request) {
+	if s.isGameMaster(UserFromContext(req)) {
+		http.Redirect(writer, req, "/dice", http.StatusSeeOther)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		data := struct {
+			Rooms map[string]*Room
+		}{
+			Rooms: s.Rooms,
+		}
+
+		if err := s.Renderer.ExecutePage(writer, "game_master_login", data); err != nil {
+			s.handleErr(writer, req, internalErr(err, "failed to execute game master login template"))
 			return
 		}
 
-		http.SetCookie(writer, dataCookie)
-		http.Redirect(writer, req, "/dice", http.StatusSeeOther)
+	case http.MethodPost:
+		if err := req.ParseForm(); err != nil {
+			s.handleErr(writer, req, validationErr("Failed to parse form: %v", err))
+			return
+		}
+
+		name := req.Form.Get("name")
+		password := req.Form.Get("password")
+
+		if name == "" {
+			s.handleErr(writer, req, validationErr("You must enter a name."))
+			return
+		}
+
+		room, ok := s.Rooms[req.Form.Get("room")]
+		if !ok || !room.checkGameMasterPassword(password) {
+			s.handleErr(writer, req, authErr("Invalid room or game master password"))
+			return
+		}
+
+		user := &User{
+			Name:          name,
+			CharacterName: room.Config.GameMasterName,
+			Room:          room.Name(),
+			IsGameMaster:  true,
+			IPAddress:     requestIP(req),
+		}
+		user.GameMasterClaim = room.gameMasterClaim(user)
+
+		s.login(writer, req, user)
 	default:
-		s.doErr(writer, fmt.Sprintf("Invalid HTTP method: %q", req.Method))
+		s.handleErr(writer, req, validationErr("Invalid HTTP method: %q", req.Method))
+		return
+	}
+}
+
+func (s *Server) login(writer http.ResponseWriter, req *http.Request, user *User) {
+	dataCookie, err := user.DataCookie(s.keys.Current)
+	if err != nil {
+		s.handleErr(writer, req, internalErr(err, "failed to save cookie"))
+		return
+	}
+
+	http.SetCookie(writer, dataCookie)
+	http.Redirect(writer, req, s.Rooms[user.Room].URL("dice"), http.StatusSeeOther)
+}
+
+// UserRoomHandler sends the user to their room, logging them out if it no longer exists.
+func (s *Server) UserRoomHandler(writer http.ResponseWriter, req *http.Request) {
+	user := UserFromContext(req)
+
+	room, ok := s.Rooms[user.Room]
+	if !ok {
+		s.logout(writer, req)
 		return
 	}
+
+	http.Redirect(writer, req, room.URL("dice"), http.StatusSeeOther)
+}
+
+func requestIP(req *http.Request) string {
+	if forwarded := req.Header.Get("X-Forwarded-For"); forwarded != "" {
+		return maskIP(forwarded)
+	}
+
+	return maskIP(req.RemoteAddr)
 }
 
 func maskIP(input string) string {
@@ -80,119 +153,159 @@ func maskIP(input string) string {
 
 func (s *Server) DiceHandler(writer http.ResponseWriter, req *http.Request) {
 	user := UserFromContext(req)
+	room := RoomFromContext(req)
 
 	data := struct {
 		User    *User
+		Room    *Room
 		History Rolls
 		Stats   *Stats
+		Rerolls *RerollRules
 		OOB     bool
 	}{
 		User:    user,
-		History: s.Rolls.Sort(),
-		Stats:   s.Stats,
+		Room:    room,
+		History: room.History(),
+		Stats:   room.Stats,
+		Rerolls: s.Opts.Config.Rerolls,
 		OOB:     true,
 	}
 
 	if err := s.Renderer.ExecutePage(writer, "dice", data); err != nil {
-		s.doErr(writer, fmt.Sprintf("Failed to execute dice template: %v", err))
+		s.handleErr(writer, req, internalErr(err, "failed to execute dice template"))
 		return
 	}
 }
 
 func (s *Server) RollHandler(writer http.ResponseWriter, req *http.Request) {
 	user := UserFromContext(req)
+	room := RoomFromContext(req)
 
 	if err := req.ParseForm(); err != nil {
-		s.doErr(writer, fmt.Sprintf("failed to parse form: %v", err))
+		s.handleErr(writer, req, validationErr("Failed to parse form: %v", err))
 		return
 	}
 
-	sides, err := strconv.ParseInt(req.Form.Get("sides"), 10, 64)
+	expr, err := room.characterDice(user, req.Form)
 	if err != nil {
-		s.doErr(writer, fmt.Sprintf("invalid number of sides: %v", err))
+		s.handleErr(writer, req, err)
 		return
 	}
 
-	num, err := strconv.ParseInt(req.Form.Get("num"), 10, 64)
-	if err != nil {
-		s.doErr(writer, fmt.Sprintf("invalid number of dice: %v", err))
-		return
-	}
+	if rawBuy := req.Form.Get("buy-dice"); rawBuy != "" {
+		buy, err := strconv.Atoi(rawBuy)
+		if err != nil {
+			s.handleErr(writer, req, validationErr("Extra dice must be a whole number."))
+			return
+		}
 
-	critOn, err := strconv.ParseInt(req.Form.Get("crit-on"), 10, 64)
-	if err != nil {
-		s.doErr(writer, fmt.Sprintf("invalid 'crit on' number: %v", err))
-		return
+		if err := expr.BuyDice(buy); err != nil {
+			s.handleErr(writer, req, err)
+			return
+		}
 	}
 
-	complicationOn, err := strconv.ParseInt(req.Form.Get("complication-on"), 10, 64)
-	if err != nil {
-		s.doErr(writer, fmt.Sprintf("invalid 'complication on' number: %v", err))
-		return
-	}
+	if rawTask := req.Form.Get("extended-task"); rawTask != "" {
+		id, err := strconv.Atoi(rawTask)
+		if err != nil {
+			s.handleErr(writer, req, validationErr("Extended task must be a number."))
+			return
+		}
 
-	dice := NewDice(int(sides), int(num), int(critOn), int(complicationOn))
-	roll := dice.Roll(user)
+		if err := room.checkExtendedTask(id); err != nil {
+			s.handleErr(writer, req, err)
+			return
+		}
 
-	s.rollMutex.Lock()
-	s.Rolls = append(s.Rolls, roll)
-	s.rollMutex.Unlock()
+		expr.ExtendedTask = id
+	}
 
-	s.NotifyClients(EventTypeRoll)
+	if req.Form.Get("spend-determination") != "" {
+		if err := expr.AddDetermination(); err != nil {
+			s.handleErr(writer, req, err)
+			return
+		}
+	}
 
-	data := struct {
-		User    *User
-		History Rolls
-		OOB     bool
-	}{
-		User:    user,
-		History: s.Rolls.Sort(),
-		OOB:     false,
+	if err := s.Opts.Config.DiceLimits.Check(expr); err != nil {
+		s.handleErr(writer, req, err)
+		return
 	}
 
-	if err := s.Renderer.ExecuteSingle(writer, "history", data); err != nil {
-		s.doErr(writer, fmt.Sprintf("failed to execute history template: %v", err))
+	if expr.Determination {
+		if err := room.Stats.SpendDetermination(user.CharacterName); err != nil {
+			s.handleErr(writer, req, err)
+			return
+		}
+	}
+
+	if _, err := room.Roll(user, expr, req.Form.Get("add-momentum") != ""); err != nil {
+		s.handleErr(writer, req, internalErr(err, "failed to save roll"))
 		return
 	}
+
+	// the roll reaches this client through SSE like everybody else
+	writer.WriteHeader(http.StatusNoContent)
 }
 
 func (s *Server) HistoryHandler(writer http.ResponseWriter, req *http.Request) {
+	room := RoomFromContext(req)
+
+	if wantsJSON(req) {
+		s.APIRollsHandler(writer, req)
+		return
+	}
+
 	data := struct {
 		History Rolls
 		OOB     bool
 	}{
-		History: s.Rolls.Sort(),
+		History: room.History(),
 		OOB:     false,
 	}
 
 	if err := s.Renderer.ExecuteSingle(writer, "history", data); err != nil {
-		s.doErr(writer, fmt.Sprintf("failed to execute history template: %v", err))
+		s.handleErr(writer, req, internalErr(err, "failed to execute history template"))
 		return
 	}
 }
 
 func (s *Server) StatsHandler(writer http.ResponseWriter, req *http.Request) {
-	if err := s.Renderer.ExecuteSingle(writer, "stats", s.Stats); err != nil {
-		s.doErr(writer, fmt.Sprintf("failed to execute history template: %v", err))
+	room := RoomFromContext(req)
+
+	if wantsJSON(req) {
+		s.APIStatsHandler(writer, req)
+		return
+	}
+
+	if err := s.Renderer.ExecuteSingle(writer, "stats", room.Stats); err != nil {
+		s.handleErr(writer, req, internalErr(err, "failed to execute stats template"))
 		return
 	}
 }
 
 func (s *Server) GameMasterHandler(writer http.ResponseWriter, req *http.Request) {
+	room := RoomFromContext(req)
+
 	if err := req.ParseForm(); err != nil {
-		s.doErr(writer, fmt.Sprintf("failed to parse form: %v", err))
+		s.handleErr(writer, req, validationErr("Failed to parse form: %v", err))
 		return
 	}
 
 	threat, err := strconv.ParseInt(req.Form.Get("threat"), 10, 64)
 	if err != nil {
-		s.doErr(writer, fmt.Sprintf("invalid number of threat: %v", err))
+		s.handleErr(writer, req, validationErr("Threat must be a whole number."))
 		return
 	}
 
 	momentum, err := strconv.ParseInt(req.Form.Get("momentum"), 10, 64)
 	if err != nil {
-		s.doErr(writer, fmt.Sprintf("invalid number of momentum: %v", err))
+		s.handleErr(writer, req, validationErr("Momentum must be a whole number."))
+		return
+	}
+
+	if momentum < 0 || momentum > MaxMomentum || threat < 0 {
+		s.handleErr(writer, req, validationErr("Momentum must be between 0 and %d, and Threat can't be negative.", MaxMomentum))
 		return
 	}
 
@@ -207,61 +320,155 @@ func (s *Server) GameMasterHandler(writer http.ResponseWriter, req *http.Request
 		}
 	}
 
-	// characterTraitsRaw := req.Form.Get("character-traits")
+	room.Stats.SetSceneTraits(sceneTraits)
+
+	momentumValue, threatValue := int(momentum), int(threat)
+
+	// changing the pools records a transaction, which saves and sends the stats itself
+	_, changed, err := room.SetPools(UserFromContext(req), &momentumValue, &threatValue)
+	if err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	if changed {
+		return
+	}
+
+	if err := room.saveStats(); err != nil {
+		s.handleErr(writer, req, internalErr(err, "failed to save stats"))
+		return
+	}
 
-	s.Stats.SetThreat(int(threat))
-	s.Stats.SetMomentum(int(momentum))
-	s.Stats.SetSceneTraits(sceneTraits)
-	s.NotifyClients(EventTypeStats)
+	room.NotifyClients(EventTypeStats)
 }
 
 func (s *Server) PrivateRollHandler(writer http.ResponseWriter, req *http.Request) {
 	user := UserFromContext(req)
 
 	if err := req.ParseForm(); err != nil {
-		s.doErr(writer, fmt.Sprintf("failed to parse form: %v", err))
+		s.handleErr(writer, req, validationErr("Failed to parse form: %v", err))
 		return
 	}
 
-	sides, err := strconv.ParseInt(req.Form.Get("sides"), 10, 64)
+	expr, err := diceFromForm(req.Form)
 	if err != nil {
-		s.doErr(writer, fmt.Sprintf("invalid number of sides: %v", err))
+		s.handleErr(writer, req, err)
 		return
 	}
 
-	num, err := strconv.ParseInt(req.Form.Get("num"), 10, 64)
-	if err != nil {
-		s.doErr(writer, fmt.Sprintf("invalid number of dice: %v", err))
+	if err := s.Opts.Config.DiceLimits.Check(expr); err != nil {
+		s.handleErr(writer, req, err)
 		return
 	}
 
-	critOn, err := strconv.ParseInt(req.Form.Get("crit-on"), 10, 64)
-	if err != nil {
-		s.doErr(writer, fmt.Sprintf("invalid 'crit on' number: %v", err))
+	roll := expr.Roll(user)
+
+	if err := s.Renderer.ExecuteSingle(writer, "private_roll", roll); err != nil {
+		s.handleErr(writer, req, internalErr(err, "failed to execute private roll template"))
 		return
 	}
+}
 
-	complicationOn, err := strconv.ParseInt(req.Form.Get("complication-on"), 10, 64)
+// diceFromForm reads a dice expression and the optional "target" and "difficulty" task fields from the form. A target
+// number given in the expression takes precedence over the form field.
+func diceFromForm(form url.Values) (*DiceExpression, error) {
+	expr, err := expressionFromForm(form)
 	if err != nil {
-		s.doErr(writer, fmt.Sprintf("invalid 'complication on' number: %v", err))
-		return
+		return nil, err
 	}
 
-	dice := NewDice(int(sides), int(num), int(critOn), int(complicationOn))
-	roll := dice.Roll(user)
+	if rawTarget := form.Get("target"); rawTarget != "" && expr.Target == 0 && !expr.Challenge {
+		target, err := strconv.ParseInt(rawTarget, 10, 64)
+		if err != nil {
+			return nil, validationErr("Target number must be a whole number.")
+		}
 
-	if err := s.Renderer.ExecuteSingle(writer, "private_roll", roll); err != nil {
-		s.doErr(writer, fmt.Sprintf("failed to execute history template: %v", err))
-		return
+		expr.Target = int(target)
+	}
+
+	if rawDifficulty := form.Get("difficulty"); rawDifficulty != "" {
+		difficulty, err := strconv.ParseInt(rawDifficulty, 10, 64)
+		if err != nil {
+			return nil, validationErr("Difficulty must be a whole number.")
+		}
+
+		expr.Difficulty = int(difficulty)
 	}
+
+	return expr, nil
 }
 
-func (s *Server) doErr(writer http.ResponseWriter, message string) {
-	writer.WriteHeader(http.StatusInternalServerError)
+// expressionFromForm reads a dice expression from the "expression" field, falling back to the individual dice fields
+// when it is empty.
+func expressionFromForm(form url.Values) (*DiceExpression, error) {
+	if expression := strings.TrimSpace(form.Get("expression")); expression != "" {
+		return ParseDiceNotation(expression)
+	}
+
+	if rawChallenge := form.Get("challenge-dice"); rawChallenge != "" {
+		num, err := strconv.ParseInt(rawChallenge, 10, 64)
+		if err != nil {
+			return nil, validationErr("Number of challenge dice must be a whole number.")
+		}
+
+		expr := &DiceExpression{
+			Challenge: true,
+			Count:     int(num),
+			Sides:     6,
+		}
+
+		return expr, nil
+	}
 
-	if _, writeErr := writer.Write([]byte(fmt.Sprintf("ERROR: %s\n", message))); writeErr != nil {
-		fmt.Fprintf(os.Stderr, "failed to write error response: %v\noriginal error: %v\n", writeErr, message)
+	sides, err := strconv.ParseInt(form.Get("sides"), 10, 64)
+	if err != nil {
+		return nil, validationErr("Number of sides must be a whole number.")
 	}
+
+	num, err := strconv.ParseInt(form.Get("num"), 10, 64)
+	if err != nil {
+		return nil, validationErr("Number of dice must be a whole number.")
+	}
+
+	critOn, err := strconv.ParseInt(form.Get("crit-on"), 10, 64)
+	if err != nil {
+		return nil, validationErr("'Crit on' must be a whole number.")
+	}
+
+	complicationOn, err := strconv.ParseInt(form.Get("complication-on"), 10, 64)
+	if err != nil {
+		return nil, validationErr("'Complication on' must be a whole number.")
+	}
+
+	// a focus or complication range of 0 means the default, so these can't be left to DiceLimits.Check
+	if sides >= 1 {
+		var fields []FieldError
+
+		if critOn < 1 || critOn > sides {
+			fields = append(fields, FieldError{Field: "crit_on", Message: fmt.Sprintf("'Crit on' must be between 1 and %d.", sides)})
+		}
+
+		if complicationOn < 1 || complicationOn > sides {
+			fields = append(fields, FieldError{Field: "complication_on", Message: fmt.Sprintf("'Complication on' must be between 1 and %d.", sides)})
+		}
+
+		if len(fields) > 0 {
+			err := validationErr("Can't roll %dd%d.", num, sides)
+			err.Fields = fields
+
+			return nil, err
+		}
+	}
+
+	expr := &DiceExpression{
+		Count:             int(num),
+		Sides:             int(sides),
+		Focus:             int(critOn),
+		ComplicationRange: int(sides - complicationOn + 1),
+	}
+
+	return expr, nil
 }
 
 func (s *Server) logout(writer http.ResponseWriter, req *http.Request) {
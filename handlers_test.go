@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestDiceFromForm(t *testing.T) {
+	form := func(critOn, complicationOn, target string) url.Values {
+		return url.Values{
+			"sides":           {"20"},
+			"num":             {"2"},
+			"crit-on":         {critOn},
+			"complication-on": {complicationOn},
+			"target":          {target},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		form    url.Values
+		want    *DiceExpression
+		wantErr string
+		fields  []string
+	}{
+		{
+			name: "focus and complication range",
+			form: form("3", "19", "12"),
+			want: &DiceExpression{Count: 2, Sides: 20, Target: 12, Focus: 3, ComplicationRange: 2},
+		},
+		{
+			name: "no target",
+			form: form("1", "20", ""),
+			want: &DiceExpression{Count: 2, Sides: 20, Focus: 1, ComplicationRange: 1},
+		},
+		{
+			name: "the expression wins over the fields",
+			form: url.Values{"expression": {"3d20t9f2"}, "crit-on": {"0"}, "target": {"12"}},
+			want: &DiceExpression{Count: 3, Sides: 20, Target: 9, Focus: 2},
+		},
+		{name: "crit on 0", form: form("0", "20", "10"), wantErr: "Can't roll 2d20.", fields: []string{"crit_on"}},
+		{
+			name:    "crit on past the sides",
+			form:    form("21", "20", ""),
+			wantErr: "Can't roll 2d20.",
+			fields:  []string{"crit_on"},
+		},
+		{
+			name:    "crit on and complication on both wrong",
+			form:    form("0", "0", ""),
+			wantErr: "Can't roll 2d20.",
+			fields:  []string{"crit_on", "complication_on"},
+		},
+		{
+			name:    "crit on above the target",
+			form:    form("12", "20", "10"),
+			wantErr: "Can't roll 2d20t10f12c1.",
+			fields:  []string{"crit_on"},
+		},
+		{name: "crit on isn't a number", form: form("one", "20", ""), wantErr: "'Crit on' must be a whole number."},
+	}
+
+	limits := &DiceLimits{}
+	limits.withDefaults()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expr, err := diceFromForm(test.form)
+			if err == nil {
+				err = limits.Check(expr)
+			}
+
+			if mismatch := errMismatch(err, test.wantErr); mismatch != "" {
+				t.Fatal(mismatch)
+			}
+
+			if err != nil {
+				if fields := errFields(err); !reflect.DeepEqual(fields, test.fields) {
+					t.Errorf("got fields %v, want %v", fields, test.fields)
+				}
+
+				return
+			}
+
+			if !reflect.DeepEqual(expr, test.want) {
+				t.Errorf("got %+v, want %+v", expr, test.want)
+			}
+		})
+	}
+}
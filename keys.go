@@ -0,0 +1,245 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	EnvSecretKey         = "D20_SECRET_KEY"
+	EnvPreviousSecretKey = "D20_PREVIOUS_SECRET_KEY"
+	EnvKeyRotatedAt      = "D20_KEY_ROTATED_AT"
+
+	defaultKeyFileName = "secret.key"
+)
+
+// Keyring holds the key used to encrypt cookies, plus the key it replaced. The previous key is still accepted for
+// decryption until PreviousUntil so that rotating the key doesn't log everybody out.
+type Keyring struct {
+	Current       []byte
+	Previous      []byte
+	PreviousUntil time.Time
+}
+
+// Keys returns the keys that may currently be used to decrypt a cookie, newest first.
+func (k *Keyring) Keys() [][]byte {
+	keys := [][]byte{k.Current}
+
+	if len(k.Previous) == keySize && time.Now().Before(k.PreviousUntil) {
+		keys = append(keys, k.Previous)
+	}
+
+	return keys
+}
+
+// keyFile is the on-disk format of a secret key file.
+type keyFile struct {
+	Current   string    `json:"current"`
+	Previous  string    `json:"previous,omitempty"`
+	RotatedAt time.Time `json:"rotated_at,omitempty"`
+}
+
+// LoadKeyring finds the secret key in, from highest to lowest priority: the D20_SECRET_KEY environment variable, the
+// config's secret_key, or a key file. The key file is the config's secret_key_file, falling back to secret.key in
+// dataDir; it is generated if it doesn't exist. With none of those available, a random key is used and every cookie is
+// invalidated when the server restarts.
+func LoadKeyring(config *Config, dataDir string) (*Keyring, error) {
+	grace := config.KeyRotationGrace()
+
+	if current := os.Getenv(EnvSecretKey); current != "" {
+		return keyringFromConfig(current, os.Getenv(EnvPreviousSecretKey), os.Getenv(EnvKeyRotatedAt), grace)
+	}
+
+	if config.SecretKey != "" {
+		return keyringFromConfig(config.SecretKey, config.PreviousSecretKey, config.KeyRotatedAt, grace)
+	}
+
+	path := keyFilePath(config, dataDir)
+	if path == "" {
+		fmt.Fprintf(os.Stderr, "WARNING: no secret key configured, sessions will not survive a restart\n")
+
+		current, err := generateKey()
+		if err != nil {
+			return nil, err
+		}
+
+		return &Keyring{Current: current}, nil
+	}
+
+	file, err := readKeyFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if file, err = createKeyFile(path); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	return keyringFromHex(file.Current, file.Previous, file.RotatedAt.Add(grace))
+}
+
+// RotateKeyFile replaces the current key in the key file with a new one, keeping the old key as the previous key.
+func RotateKeyFile(config *Config, dataDir string) error {
+	path := keyFilePath(config, dataDir)
+	if path == "" {
+		return fmt.Errorf("no key file configured")
+	}
+
+	file, err := readKeyFile(path)
+	if err != nil {
+		return err
+	}
+
+	current, err := generateKey()
+	if err != nil {
+		return err
+	}
+
+	rotated := &keyFile{
+		Current:   hex.EncodeToString(current),
+		Previous:  file.Current,
+		RotatedAt: time.Now().UTC(),
+	}
+
+	if err := writeKeyFile(path, rotated); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func keyFilePath(config *Config, dataDir string) string {
+	switch {
+	case config.SecretKeyFile != "":
+		return config.SecretKeyFile
+	case dataDir != "":
+		return filepath.Join(dataDir, defaultKeyFileName)
+	}
+
+	return ""
+}
+
+// keyringFromConfig makes a keyring from keys given in the environment or config. The previous key must come with the
+// time it was replaced, like the key file's rotated_at, so that restarting the server doesn't extend its grace period.
+func keyringFromConfig(current, previous, rotatedAt string, grace time.Duration) (*Keyring, error) {
+	if previous == "" {
+		return keyringFromHex(current, "", time.Time{})
+	}
+
+	if rotatedAt == "" {
+		return nil, fmt.Errorf("the previous secret key needs the time the key was rotated (%s or key_rotated_at)",
+			EnvKeyRotatedAt)
+	}
+
+	rotated, err := time.Parse(time.RFC3339, rotatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key rotation time: %w", err)
+	}
+
+	return keyringFromHex(current, previous, rotated.Add(grace))
+}
+
+func keyringFromHex(current, previous string, previousUntil time.Time) (*Keyring, error) {
+	currentKey, err := decodeKey(current)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret key: %w", err)
+	}
+
+	keyring := &Keyring{Current: currentKey}
+
+	if previous != "" {
+		previousKey, err := decodeKey(previous)
+		if err != nil {
+			return nil, fmt.Errorf("invalid previous secret key: %w", err)
+		}
+
+		keyring.Previous = previousKey
+		keyring.PreviousUntil = previousUntil
+	}
+
+	return keyring, nil
+}
+
+func decodeKey(value string) ([]byte, error) {
+	key, err := hex.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("key must be hex-encoded: %w", err)
+	}
+
+	if len(key) != keySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", keySize, len(key))
+	}
+
+	return key, nil
+}
+
+func generateKey() ([]byte, error) {
+	key := make([]byte, keySize)
+
+	n, err := rand.Read(key)
+	if n != keySize {
+		return nil, fmt.Errorf("invalid number of secret key bytes (%d)", n)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to generate secret key: %w", err)
+	}
+
+	return key, nil
+}
+
+func readKeyFile(path string) (*keyFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file %q: %w", path, err)
+	}
+
+	if info.Mode().Perm()&0o077 != 0 {
+		return nil, fmt.Errorf("key file %q must not be accessible by group or others (mode %s)", path, info.Mode().Perm())
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file %q: %w", path, err)
+	}
+
+	file := &keyFile{}
+	if err := json.Unmarshal(data, file); err != nil {
+		return nil, fmt.Errorf("failed to parse key file %q: %w", path, err)
+	}
+
+	return file, nil
+}
+
+func createKeyFile(path string) (*keyFile, error) {
+	current, err := generateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	file := &keyFile{Current: hex.EncodeToString(current)}
+
+	if err := writeKeyFile(path, file); err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Generated new secret key in %s\n", path)
+
+	return file, nil
+}
+
+func writeKeyFile(path string, file *keyFile) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create key file directory: %w", err)
+	}
+
+	if err := writeJSONFile(path, file); err != nil {
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+
+	return nil
+}
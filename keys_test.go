@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadKeyringFromConfig(t *testing.T) {
+	current := strings.Repeat("ab", keySize)
+	previous := strings.Repeat("cd", keySize)
+
+	tests := []struct {
+		name    string
+		config  Config
+		env     map[string]string
+		keys    int
+		wantErr string
+	}{
+		{name: "current key only", config: Config{SecretKey: current}, keys: 1},
+		{
+			name:   "previous key in its grace period",
+			config: Config{SecretKey: current, PreviousSecretKey: previous, KeyRotatedAt: time.Now().Format(time.RFC3339)},
+			keys:   2,
+		},
+		{
+			name: "previous key past its grace period",
+			config: Config{
+				SecretKey:         current,
+				PreviousSecretKey: previous,
+				KeyRotatedAt:      time.Now().Add(-25 * time.Hour).Format(time.RFC3339),
+			},
+			keys: 1,
+		},
+		{
+			name: "longer grace period",
+			config: Config{
+				SecretKey:         current,
+				PreviousSecretKey: previous,
+				KeyRotatedAt:      time.Now().Add(-25 * time.Hour).Format(time.RFC3339),
+				RotationGrace:     "48h",
+			},
+			keys: 2,
+		},
+		{
+			name:    "previous key without a rotation time",
+			config:  Config{SecretKey: current, PreviousSecretKey: previous},
+			wantErr: "the previous secret key needs the time the key was rotated",
+		},
+		{
+			name:    "invalid rotation time",
+			config:  Config{SecretKey: current, PreviousSecretKey: previous, KeyRotatedAt: "yesterday"},
+			wantErr: "invalid key rotation time",
+		},
+		{name: "short key", config: Config{SecretKey: "abcd"}, wantErr: "invalid secret key"},
+		{
+			name:   "environment wins over the config",
+			config: Config{SecretKey: "not even hex"},
+			env: map[string]string{
+				EnvSecretKey:         current,
+				EnvPreviousSecretKey: previous,
+				EnvKeyRotatedAt:      time.Now().Format(time.RFC3339),
+			},
+			keys: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, name := range []string{EnvSecretKey, EnvPreviousSecretKey, EnvKeyRotatedAt} {
+				t.Setenv(name, test.env[name])
+			}
+
+			keyring, err := LoadKeyring(&test.config, "")
+
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want %q", err, test.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if keys := keyring.Keys(); len(keys) != test.keys {
+				t.Errorf("got %d keys, want %d", len(keys), test.keys)
+			}
+		})
+	}
+}
+
+func TestKeyFileRotation(t *testing.T) {
+	for _, name := range []string{EnvSecretKey, EnvPreviousSecretKey, EnvKeyRotatedAt} {
+		t.Setenv(name, "")
+	}
+
+	dataDir := t.TempDir()
+	config := &Config{}
+
+	first, err := LoadKeyring(config, dataDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// a restart loads the same key
+	again, err := LoadKeyring(config, dataDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !bytes.Equal(first.Current, again.Current) || len(again.Keys()) != 1 {
+		t.Fatalf("got a different keyring after loading the key file again")
+	}
+
+	if err := RotateKeyFile(config, dataDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rotated, err := LoadKeyring(config, dataDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	keys := rotated.Keys()
+	if len(keys) != 2 || bytes.Equal(keys[0], first.Current) || !bytes.Equal(keys[1], first.Current) {
+		t.Fatalf("got keys %x, want a new key followed by the old one", keys)
+	}
+
+	// the grace period runs from the rotation, not from when the server started
+	rotated, err = LoadKeyring(&Config{RotationGrace: "1ns"}, dataDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(rotated.Keys()) != 1 {
+		t.Errorf("got the previous key after its grace period")
+	}
+
+	path := filepath.Join(dataDir, defaultKeyFileName)
+	if err := os.Chmod(path, 0o644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := LoadKeyring(config, dataDir); err == nil || !strings.Contains(err.Error(), "must not be accessible") {
+		t.Errorf("got error %v for a world-readable key file, want a permissions error", err)
+	}
+}
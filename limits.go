@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	defaultMaxDice          = 20
+	defaultMaxChallengeDice = 20
+	defaultMaxModifier      = 100
+)
+
+var defaultAllowedSides = []int{2, 3, 4, 6, 8, 10, 12, 20, 100}
+
+// DiceLimits bounds what players can ask the server to roll, so a typo (or a malicious form) can't allocate millions
+// of dice or roll a die with no sides.
+type DiceLimits struct {
+	MaxDice          int   `json:"max_dice"`
+	MaxChallengeDice int   `json:"max_challenge_dice"`
+	AllowedSides     []int `json:"allowed_sides"`
+	MaxModifier      int   `json:"max_modifier"`
+}
+
+// withDefaults fills in any limits that aren't configured.
+func (l *DiceLimits) withDefaults() {
+	if l.MaxDice == 0 {
+		l.MaxDice = defaultMaxDice
+	}
+
+	if l.MaxChallengeDice == 0 {
+		l.MaxChallengeDice = defaultMaxChallengeDice
+	}
+
+	if len(l.AllowedSides) == 0 {
+		l.AllowedSides = defaultAllowedSides
+	}
+
+	if l.MaxModifier == 0 {
+		l.MaxModifier = defaultMaxModifier
+	}
+}
+
+func (l *DiceLimits) OK() error {
+	if l.MaxDice < 1 {
+		return fmt.Errorf("max dice must be at least 1")
+	}
+
+	if l.MaxChallengeDice < 1 {
+		return fmt.Errorf("max challenge dice must be at least 1")
+	}
+
+	for _, sides := range l.AllowedSides {
+		if sides < 1 {
+			return fmt.Errorf("allowed sides must be at least 1, got %d", sides)
+		}
+	}
+
+	if l.MaxModifier < 0 {
+		return fmt.Errorf("max modifier can't be negative")
+	}
+
+	return nil
+}
+
+// FieldError explains what is wrong with a single field of a request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Check reports every way expr breaks the limits as a validation error, or nil if it can be rolled.
+func (l *DiceLimits) Check(expr *DiceExpression) error {
+	var fields []FieldError
+
+	invalid := func(field, format string, args ...any) {
+		fields = append(fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if expr.Challenge {
+		if expr.Count < 1 || expr.Count > l.MaxChallengeDice {
+			invalid("count", "Roll between 1 and %d challenge dice.", l.MaxChallengeDice)
+		}
+	} else {
+		if expr.Count < 1 || expr.Count > l.MaxDice {
+			invalid("count", "Roll between 1 and %d dice.", l.MaxDice)
+		}
+
+		if !slices.Contains(l.AllowedSides, expr.Sides) {
+			invalid("sides", "Dice must have %s sides.", formatSides(l.AllowedSides))
+		}
+
+		// the rest only make sense once we know how many sides there are
+		if expr.Sides >= 1 {
+			if expr.Focus < 0 || expr.Focus > expr.Sides {
+				invalid("crit_on", "'Crit on' must be between 1 and %d.", expr.Sides)
+			}
+
+			if expr.ComplicationRange < 0 || expr.ComplicationRange > expr.Sides {
+				invalid("complication_on", "'Complication on' must be between 1 and %d.", expr.Sides)
+			}
+
+			if expr.Target < 0 || expr.Target > expr.Sides {
+				invalid("target", "Target number must be between 1 and %d.", expr.Sides)
+			}
+
+			if expr.Target > 0 && expr.Focus > expr.Target {
+				invalid("crit_on", "'Crit on' can't be above the target number.")
+			}
+		}
+
+		if expr.Keep < 0 || expr.Keep > expr.Count {
+			invalid("keep", "Can't keep more dice than you roll.")
+		}
+
+		if expr.Drop < 0 || (expr.Drop > 0 && expr.Drop >= expr.Count) {
+			invalid("drop", "Can't drop every die you roll.")
+		}
+
+		if expr.Difficulty < 0 {
+			invalid("difficulty", "Difficulty can't be negative.")
+		}
+	}
+
+	if expr.Modifier < -l.MaxModifier || expr.Modifier > l.MaxModifier {
+		invalid("modifier", "Modifier must be between -%d and %d.", l.MaxModifier, l.MaxModifier)
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+
+	err := validationErr("Can't roll %s.", expr)
+	err.Fields = fields
+
+	return err
+}
+
+// formatSides lists the allowed sides like "2, 6 or 20".
+func formatSides(sides []int) string {
+	parts := make([]string, 0, len(sides))
+	for _, side := range sides {
+		parts = append(parts, fmt.Sprint(side))
+	}
+
+	if len(parts) == 1 {
+		return parts[0]
+	}
+
+	return strings.Join(parts[:len(parts)-1], ", ") + " or " + parts[len(parts)-1]
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiceLimitsCheck(t *testing.T) {
+	limits := &DiceLimits{MaxDice: 5, MaxChallengeDice: 4, AllowedSides: []int{6, 20}, MaxModifier: 10}
+
+	tests := []struct {
+		name     string
+		expr     DiceExpression
+		fields   []string
+		messages []string
+	}{
+		{name: "within the limits", expr: DiceExpression{Count: 5, Sides: 20, Target: 12, Focus: 3, Modifier: -10}},
+		{name: "challenge dice", expr: DiceExpression{Challenge: true, Count: 4, Sides: 6}},
+		{
+			name:     "too many dice",
+			expr:     DiceExpression{Count: 6, Sides: 20},
+			fields:   []string{"count"},
+			messages: []string{"Roll between 1 and 5 dice."},
+		},
+		{
+			name:     "no dice",
+			expr:     DiceExpression{Count: 0, Sides: 6},
+			fields:   []string{"count"},
+			messages: []string{"Roll between 1 and 5 dice."},
+		},
+		{
+			name:     "too many challenge dice",
+			expr:     DiceExpression{Challenge: true, Count: 5, Sides: 6},
+			fields:   []string{"count"},
+			messages: []string{"Roll between 1 and 4 challenge dice."},
+		},
+		{
+			name:     "sides not allowed",
+			expr:     DiceExpression{Count: 1, Sides: 8},
+			fields:   []string{"sides"},
+			messages: []string{"Dice must have 6 or 20 sides."},
+		},
+		{
+			name:   "thresholds past the sides",
+			expr:   DiceExpression{Count: 2, Sides: 6, Focus: 7, ComplicationRange: 7, Target: 7},
+			fields: []string{"crit_on", "complication_on", "target"},
+			messages: []string{
+				"'Crit on' must be between 1 and 6.",
+				"'Complication on' must be between 1 and 6.",
+				"Target number must be between 1 and 6.",
+			},
+		},
+		{
+			name:     "crit on above the target",
+			expr:     DiceExpression{Count: 2, Sides: 20, Focus: 11, Target: 10},
+			fields:   []string{"crit_on"},
+			messages: []string{"'Crit on' can't be above the target number."},
+		},
+		{
+			name:     "keeping more than rolled",
+			expr:     DiceExpression{Count: 2, Sides: 20, Keep: 3},
+			fields:   []string{"keep"},
+			messages: []string{"Can't keep more dice than you roll."},
+		},
+		{
+			name:     "dropping every die",
+			expr:     DiceExpression{Count: 2, Sides: 20, Drop: 2},
+			fields:   []string{"drop"},
+			messages: []string{"Can't drop every die you roll."},
+		},
+		{
+			name:     "negative difficulty",
+			expr:     DiceExpression{Count: 2, Sides: 20, Target: 10, Difficulty: -1},
+			fields:   []string{"difficulty"},
+			messages: []string{"Difficulty can't be negative."},
+		},
+		{
+			name:     "modifier past the limit",
+			expr:     DiceExpression{Challenge: true, Count: 1, Sides: 6, Modifier: 11},
+			fields:   []string{"modifier"},
+			messages: []string{"Modifier must be between -10 and 10."},
+		},
+		{
+			name:   "everything at once",
+			expr:   DiceExpression{Count: 9, Sides: 7, Focus: 8, Target: 8, Modifier: -11},
+			fields: []string{"count", "sides", "crit_on", "target", "modifier"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := limits.Check(&test.expr)
+
+			if test.fields == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				return
+			}
+
+			if mismatch := errMismatch(err, "Can't roll "+test.expr.String()+"."); mismatch != "" {
+				t.Fatal(mismatch)
+			}
+
+			if fields := errFields(err); !reflect.DeepEqual(fields, test.fields) {
+				t.Errorf("got fields %v, want %v", fields, test.fields)
+			}
+
+			if test.messages == nil {
+				return
+			}
+
+			var messages []string
+			for _, field := range asHTTPError(err).Fields {
+				messages = append(messages, field.Message)
+			}
+
+			if !reflect.DeepEqual(messages, test.messages) {
+				t.Errorf("got %q, want %q", messages, test.messages)
+			}
+		})
+	}
+}
+
+func TestDiceLimitsDefaults(t *testing.T) {
+	limits := &DiceLimits{MaxDice: 3}
+	limits.withDefaults()
+
+	want := &DiceLimits{
+		MaxDice:          3,
+		MaxChallengeDice: defaultMaxChallengeDice,
+		AllowedSides:     defaultAllowedSides,
+		MaxModifier:      defaultMaxModifier,
+	}
+
+	if !reflect.DeepEqual(limits, want) {
+		t.Errorf("got %+v, want %+v", limits, want)
+	}
+
+	if err := (&DiceLimits{MaxDice: 1, MaxChallengeDice: 1, AllowedSides: []int{0}}).OK(); err == nil {
+		t.Errorf("got no error for dice with no sides")
+	}
+}
+
+func TestFormatSides(t *testing.T) {
+	tests := []struct {
+		sides []int
+		want  string
+	}{
+		{[]int{20}, "20"},
+		{[]int{6, 20}, "6 or 20"},
+		{[]int{2, 6, 20}, "2, 6 or 20"},
+	}
+
+	for _, test := range tests {
+		if got := formatSides(test.sides); got != test.want {
+			t.Errorf("formatSides(%v) = %q, want %q", test.sides, got, test.want)
+		}
+	}
+}
@@ -1,34 +1,70 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"slices"
+	"strings"
 
 	"github.com/urfave/cli/v2"
+	"golang.org/x/crypto/bcrypt"
 )
 
-func runServer(ctx *cli.Context) error {
+func loadConfig(ctx *cli.Context) (*Config, error) {
 	configFile := ctx.String("config")
 
 	configBytes, err := os.ReadFile(configFile)
 	if err != nil {
-		return fmt.Errorf("invalid config file %q: %w", configFile, err)
+		return nil, fmt.Errorf("invalid config file %q: %w", configFile, err)
 	}
 
 	config := &Config{}
 	if err := json.Unmarshal(configBytes, config); err != nil {
-		return fmt.Errorf("failed to parse config file %q: %w", configFile, err)
+		return nil, fmt.Errorf("failed to parse config file %q: %w", configFile, err)
 	}
 
 	if err := config.OK(); err != nil {
-		return fmt.Errorf("config error: %w", err)
+		return nil, fmt.Errorf("config error: %w", err)
+	}
+
+	return config, nil
+}
+
+func runServer(ctx *cli.Context) error {
+	config, err := loadConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	keys, err := LoadKeyring(config, ctx.String("data-dir"))
+	if err != nil {
+		return fmt.Errorf("failed to load secret key: %w", err)
+	}
+
+	stores := make(map[string]Store, len(config.Rooms))
+
+	for _, room := range config.Rooms {
+		store, err := NewStore(ctx.String("store"), RoomDataDir(ctx.String("data-dir"), room.Name))
+		if err != nil {
+			return fmt.Errorf("failed to set up storage for room %q: %w", room.Name, err)
+		}
+		defer store.Close()
+
+		stores[room.Name] = store
 	}
 
 	serverOpts := &ServerOpts{
 		Host:   ctx.String("host"),
 		Port:   ctx.Int("port"),
 		Config: config,
+		Stores: stores,
+		Keys:   keys,
+
+		Metrics: ctx.Bool("metrics"),
 	}
 
 	server, err := NewServer(serverOpts)
@@ -43,7 +79,178 @@ func runServer(ctx *cli.Context) error {
 	return nil
 }
 
+func rotateKey(ctx *cli.Context) error {
+	config, err := loadConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := RotateKeyFile(config, ctx.String("data-dir")); err != nil {
+		return fmt.Errorf("failed to rotate secret key: %w", err)
+	}
+
+	fmt.Printf("Rotated secret key, restart the server to start using it. The previous key is accepted for %s.\n", config.KeyRotationGrace())
+
+	return nil
+}
+
+func hashPassword(ctx *cli.Context) error {
+	fmt.Fprint(os.Stderr, "Game Master password: ")
+
+	reader := bufio.NewReader(os.Stdin)
+
+	password, err := reader.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to read password: %w", err)
+	}
+
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		return fmt.Errorf("password must not be empty")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	fmt.Println(string(hash))
+
+	return nil
+}
+
+// openRoomStats loads the saved stats of the room named by the --room flag, for commands that change them while the
+// server isn't running.
+func openRoomStats(ctx *cli.Context) (Store, *Stats, error) {
+	store, err := NewStore(ctx.String("store"), RoomDataDir(ctx.String("data-dir"), ctx.String("room")))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open storage for room %q: %w", ctx.String("room"), err)
+	}
+
+	state, err := store.Load()
+	if err != nil {
+		store.Close()
+		return nil, nil, fmt.Errorf("failed to load room %q: %w", ctx.String("room"), err)
+	}
+
+	if state.Stats == nil {
+		state.Stats = &Stats{}
+	}
+
+	return store, state.Stats, nil
+}
+
+func exportCharacter(ctx *cli.Context) error {
+	format, err := characterFormat(ctx.String("format"))
+	if err != nil {
+		return err
+	}
+
+	store, stats, err := openRoomStats(ctx)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	character := ctx.String("character")
+
+	sheet := stats.CharacterSheet(character)
+	if sheet == nil {
+		return fmt.Errorf("%s doesn't have a character sheet in room %q", character, ctx.String("room"))
+	}
+
+	output := os.Stdout
+
+	if path := ctx.String("output"); path != "" && path != "-" {
+		output, err = os.Create(path)
+		if err != nil {
+			return fmt.Errorf("failed to create %q: %w", path, err)
+		}
+		defer output.Close()
+	}
+
+	if err := NewCharacterFile(sheet).Encode(output, format); err != nil {
+		return fmt.Errorf("failed to write character file: %w", err)
+	}
+
+	return nil
+}
+
+func importCharacter(ctx *cli.Context) error {
+	path := ctx.Args().First()
+	if path == "" {
+		return fmt.Errorf("give the character file to import")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read character file %q: %w", path, err)
+	}
+
+	file, err := ParseCharacterFile(data)
+	if err != nil {
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) {
+			for _, field := range httpErr.Fields {
+				fmt.Fprintf(os.Stderr, "%s: %s: %s\n", path, field.Field, field.Message)
+			}
+		}
+
+		return fmt.Errorf("invalid character file %q: %w", path, err)
+	}
+
+	store, stats, err := openRoomStats(ctx)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	character := strings.TrimSpace(file.Character)
+	if override := ctx.String("character"); override != "" {
+		character = override
+	}
+
+	sheet := stats.CharacterSheet(character)
+	if sheet == nil {
+		sheet = newCharacterSheet(character)
+	}
+
+	if owner := ctx.String("owner"); owner != "" {
+		sheet.Owner = owner
+	}
+
+	file.Apply(sheet)
+	stats.SetCharacterSheet(sheet)
+
+	if err := store.SaveStats(stats); err != nil {
+		return fmt.Errorf("failed to save stats: %w", err)
+	}
+
+	fmt.Printf("Imported %s into room %q.\n", character, ctx.String("room"))
+
+	return nil
+}
+
 func setup() error {
+	// the character commands edit a room's saved stats directly, so the server must not be running
+	roomFlags := []cli.Flag{
+		&cli.StringFlag{
+			Name:     "data-dir",
+			Usage:    "directory the server saves rolls and stats in",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "store",
+			Usage: "storage format in the data directory (\"log\" or \"json\")",
+			Value: StoreKindLog,
+		},
+		&cli.StringFlag{
+			Name:  "room",
+			Usage: "room whose character sheets to use",
+			Value: defaultRoomName,
+		},
+	}
+
 	app := &cli.App{
 		Name:     "d20",
 		HelpName: "d20",
@@ -65,9 +272,85 @@ func setup() error {
 						Value:    "config.json",
 						Required: true,
 					},
+					&cli.StringFlag{
+						Name:  "data-dir",
+						Usage: "directory to save rolls and stats in; nothing is saved if empty",
+					},
+					&cli.StringFlag{
+						Name:  "store",
+						Usage: "storage format in the data directory (\"log\" or \"json\")",
+						Value: StoreKindLog,
+					},
+					&cli.BoolFlag{
+						Name:  "metrics",
+						Usage: "serve SSE and runtime metrics on /debug/vars",
+					},
 				},
 				Action: runServer,
 			},
+			{
+				Name:  "rotate-key",
+				Usage: "replace the secret key in the key file, keeping the old key valid for the grace period",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "config",
+						Value:    "config.json",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "data-dir",
+						Usage: "directory containing the key file, if secret_key_file isn't set in the config",
+					},
+				},
+				Action: rotateKey,
+			},
+			{
+				Name:  "character",
+				Usage: "move character sheets in and out of a room's saved stats while the server is stopped",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "export",
+						Usage: "write a character sheet as a character file",
+						Flags: append(slices.Clone(roomFlags),
+							&cli.StringFlag{
+								Name:     "character",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:  "format",
+								Usage: "\"json\" or \"yaml\"",
+								Value: CharacterFormatJSON,
+							},
+							&cli.StringFlag{
+								Name:  "output",
+								Usage: "file to write to instead of stdout",
+							},
+						),
+						Action: exportCharacter,
+					},
+					{
+						Name:      "import",
+						Usage:     "replace a character sheet with one from a JSON or YAML character file",
+						ArgsUsage: "FILE",
+						Flags: append(slices.Clone(roomFlags),
+							&cli.StringFlag{
+								Name:  "character",
+								Usage: "character to import into, if not the one named in the file",
+							},
+							&cli.StringFlag{
+								Name:  "owner",
+								Usage: "player name the sheet belongs to",
+							},
+						),
+						Action: importCharacter,
+					},
+				},
+			},
+			{
+				Name:   "hash-password",
+				Usage:  "read a Game Master password from stdin and print its hash for game_master_password_hash",
+				Action: hashPassword,
+			},
 		},
 	}
 
@@ -14,52 +14,72 @@ const userKey contextKey = "USER"
 
 func (s *Server) UserMiddleware(required bool, next http.HandlerFunc) http.HandlerFunc {
 	return func(writer http.ResponseWriter, req *http.Request) {
-		dataCookie, err := req.Cookie(CookieData)
+		user, err := s.userFromRequest(writer, req)
 		if err != nil {
-			if errors.Is(err, http.ErrNoCookie) {
-				if required {
-					http.Redirect(writer, req, "/", http.StatusSeeOther)
-					return
-				}
+			fmt.Fprintf(os.Stderr, "user cookie error: %v\n", err)
+			s.logout(writer, req)
 
-				next(writer, req)
+			return
+		}
 
+		if user == nil {
+			if required {
+				http.Redirect(writer, req, "/", http.StatusSeeOther)
 				return
 			}
 
-			s.doErr(writer, fmt.Sprintf("Failed to get cookie: %v", err))
+			next(writer, req)
 
 			return
 		}
 
-		if dataCookie.Value == "" {
-			s.logout(writer, req)
-			return
-		}
+		ctx := context.WithValue(req.Context(), userKey, user)
+		req = req.WithContext(ctx)
+
+		next(writer, req)
+	}
+}
+
+// userFromRequest decrypts the user from the data cookie, re-issuing the cookie if it was encrypted with a rotated key.
+// The user is nil if there is no cookie.
+func (s *Server) userFromRequest(writer http.ResponseWriter, req *http.Request) (*User, error) {
+	dataCookie, err := req.Cookie(CookieData)
+	if errors.Is(err, http.ErrNoCookie) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get cookie: %w", err)
+	}
+
+	if dataCookie.Value == "" {
+		return nil, fmt.Errorf("%w: empty cookie", ErrInvalidFormat)
+	}
 
-		user, err := UserFromCookie(dataCookie.Value, s.secretKey)
+	user, stale, err := UserFromCookie(dataCookie.Value, s.keys)
+	if err != nil {
+		return nil, err
+	}
+
+	// re-encrypt cookies still using a rotated key so they outlive the grace period
+	if stale {
+		refreshed, err := user.DataCookie(s.keys.Current)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "user cookie error: %v\n", err)
+			return nil, fmt.Errorf("failed to refresh cookie: %w", err)
 		}
 
-		if err != nil || user == nil {
-			s.logout(writer, req)
-			return
-		}
+		http.SetCookie(writer, refreshed)
+	}
 
-		ctx := context.WithValue(req.Context(), userKey, user)
-		req = req.WithContext(ctx)
+	user.IsGameMaster = s.isGameMaster(user)
 
-		next(writer, req)
-	}
+	return user, nil
 }
 
 func (s *Server) GameMasterMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(writer http.ResponseWriter, req *http.Request) {
 		user := UserFromContext(req)
 
-		if !user.IsGameMaster {
-			s.doErr(writer, "YOU ARE NOT THE GAME MASTER")
+		if !s.isGameMaster(user) {
+			s.handleErr(writer, req, forbiddenErr("Only the Game Master can do that."))
 			return
 		}
 
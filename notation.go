@@ -0,0 +1,450 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DiceExpression is a parsed dice notation string such as "2d20", "3d6+2" or "4d20t12f3".
+//
+// The grammar is:
+//
+//	expression := [count] "d" sides { modifier | option }
+//	            | [count] "cd" { modifier }   challenge dice
+//	modifier   := ("+" | "-") number
+//	option     := "t" number           target number
+//	            | "f" number           focus (crit at or below)
+//	            | "c" number           complication range (complication on the top N faces)
+//	            | ("k" | "kh") number  keep highest N
+//	            | "kl" number          keep lowest N
+//	            | "dh" number          drop highest N
+//	            | ("d" | "dl") number  drop lowest N
+//
+// Whitespace is ignored and letters are case-insensitive.
+type DiceExpression struct {
+	Challenge         bool // Count challenge dice rather than Count dice with Sides sides
+	Count             int
+	Sides             int
+	Modifier          int
+	Target            int
+	Focus             int
+	ComplicationRange int
+	Keep              int // 0 keeps every die
+	KeepLowest        bool
+	Drop              int  // drops this many dice however many are rolled, lowest unless KeepLowest is set
+	Difficulty        int  // not part of the notation, set from the roll form
+	BoughtDice        int  // extra d20s paid for with Threat, included in Count
+	ExtendedTask      int  // ID of the extended task the roll is for, set from the roll form
+	Determination     bool // the last die is a 1 paid for with Determination, included in Count
+}
+
+// ParseError describes where and why a dice expression failed to parse.
+type ParseError struct {
+	Expression string
+	Position   int
+	Message    string
+}
+
+func (p *ParseError) Error() string {
+	return fmt.Sprintf("invalid dice expression %q at position %d: %s", p.Expression, p.Position, p.Message)
+}
+
+// Marker returns the expression with a caret pointing at the offending position.
+func (p *ParseError) Marker() string {
+	return p.Expression + "\n" + strings.Repeat(" ", p.Position) + "^"
+}
+
+func ParseDiceNotation(input string) (*DiceExpression, error) {
+	parser := &notationParser{
+		input: strings.ToLower(strings.Join(strings.Fields(input), "")),
+	}
+
+	return parser.parse()
+}
+
+// BuyDice adds num extra d20s to the roll, to be paid for with Threat.
+func (d *DiceExpression) BuyDice(num int) error {
+	switch {
+	case num == 0:
+		return nil
+	case num < 0 || num > MaxBoughtDice:
+		return validationErr("You can buy up to %d extra d20s.", MaxBoughtDice)
+	case d.Challenge || d.Sides != 20:
+		return validationErr("Extra dice can only be bought for d20 rolls.")
+	}
+
+	d.Count += num
+	d.BoughtDice = num
+
+	return nil
+}
+
+// AddDetermination adds a d20 that counts as rolling a 1, paid for with a point of Determination.
+func (d *DiceExpression) AddDetermination() error {
+	if d.Challenge || d.Sides != 20 || d.Target == 0 {
+		return validationErr("Determination can only be spent on d20 tasks with a target number.")
+	}
+
+	d.Count++
+	d.Determination = true
+
+	return nil
+}
+
+// CritOn returns the highest value that counts as a crit.
+func (d *DiceExpression) CritOn() int {
+	if d.Focus == 0 {
+		return 1
+	}
+
+	return d.Focus
+}
+
+// ComplicationOn returns the lowest value that counts as a complication.
+func (d *DiceExpression) ComplicationOn() int {
+	if d.ComplicationRange == 0 {
+		return d.Sides
+	}
+
+	return d.Sides - d.ComplicationRange + 1
+}
+
+func (d *DiceExpression) Dice() Dice {
+	return NewDice(d.Sides, d.Count, d.CritOn(), d.ComplicationOn())
+}
+
+// Task returns the task described by the expression, or nil if it has no target number.
+func (d *DiceExpression) Task() *TaskOpts {
+	if d.Target == 0 {
+		return nil
+	}
+
+	return &TaskOpts{
+		Target:     d.Target,
+		Focus:      d.CritOn(),
+		Difficulty: d.Difficulty,
+	}
+}
+
+func (d *DiceExpression) Roll(user *User) Roll {
+	if d.Challenge {
+		roll := NewChallengeDice(d.Count).Roll(user)
+		roll.Expression = d.String()
+		roll.Modifier = d.Modifier
+
+		return roll
+	}
+
+	roll := d.Dice().Roll(user, nil)
+	roll.Expression = d.String()
+	roll.Modifier = d.Modifier
+
+	if d.Determination {
+		roll.Result[len(roll.Result)-1] = DieResult{Value: 1, Crit: true, Determination: true}
+	}
+
+	d.dropDice(roll.Result)
+	roll.Resolve(d.Task())
+
+	return roll
+}
+
+// dropDice marks the dice thrown away by the keep or drop option. Dropping is worked out from the dice actually
+// rolled, so buying extra dice doesn't change how many are dropped. The Determination die is never dropped.
+func (d *DiceExpression) dropDice(result DiceResults) {
+	rolled := 0
+
+	for _, dieResult := range result {
+		if !dieResult.Determination {
+			rolled++
+		}
+	}
+
+	keep := d.Keep
+	if d.Drop > 0 {
+		keep = rolled - d.Drop
+	}
+
+	if keep > 0 && keep < rolled {
+		result.keep(keep, d.KeepLowest)
+	}
+}
+
+func (d *DiceExpression) String() string {
+	var builder strings.Builder
+
+	if d.Challenge {
+		fmt.Fprintf(&builder, "%dcd", d.Count)
+	} else {
+		fmt.Fprintf(&builder, "%dd%d", d.Count, d.Sides)
+	}
+
+	if d.Modifier > 0 {
+		fmt.Fprintf(&builder, "+%d", d.Modifier)
+	} else if d.Modifier < 0 {
+		fmt.Fprintf(&builder, "%d", d.Modifier)
+	}
+
+	if d.Target > 0 {
+		fmt.Fprintf(&builder, "t%d", d.Target)
+	}
+
+	if d.Focus > 0 {
+		fmt.Fprintf(&builder, "f%d", d.Focus)
+	}
+
+	if d.ComplicationRange > 0 {
+		fmt.Fprintf(&builder, "c%d", d.ComplicationRange)
+	}
+
+	if d.Keep > 0 {
+		if d.KeepLowest {
+			fmt.Fprintf(&builder, "kl%d", d.Keep)
+		} else {
+			fmt.Fprintf(&builder, "kh%d", d.Keep)
+		}
+	}
+
+	if d.Drop > 0 {
+		if d.KeepLowest {
+			fmt.Fprintf(&builder, "dh%d", d.Drop)
+		} else {
+			fmt.Fprintf(&builder, "dl%d", d.Drop)
+		}
+	}
+
+	return builder.String()
+}
+
+type notationParser struct {
+	input    string
+	pos      int
+	focusPos int // where the "f" option was, for reporting a focus above the target
+}
+
+func (n *notationParser) errorf(pos int, format string, args ...any) *ParseError {
+	return &ParseError{
+		Expression: n.input,
+		Position:   pos,
+		Message:    fmt.Sprintf(format, args...),
+	}
+}
+
+func (n *notationParser) peek() byte {
+	if n.pos >= len(n.input) {
+		return 0
+	}
+
+	return n.input[n.pos]
+}
+
+func (n *notationParser) number() (int, bool, error) {
+	start := n.pos
+
+	for n.pos < len(n.input) && n.input[n.pos] >= '0' && n.input[n.pos] <= '9' {
+		n.pos++
+	}
+
+	if start == n.pos {
+		return 0, false, nil
+	}
+
+	value, err := strconv.Atoi(n.input[start:n.pos])
+	if err != nil {
+		return 0, false, n.errorf(start, "number %q is out of range", n.input[start:n.pos])
+	}
+
+	return value, true, nil
+}
+
+func (n *notationParser) requireNumber(what string) (int, error) {
+	start := n.pos
+
+	value, ok, err := n.number()
+	if err != nil {
+		return 0, err
+	} else if !ok {
+		return 0, n.errorf(start, "expected %s", what)
+	}
+
+	return value, nil
+}
+
+func (n *notationParser) parse() (*DiceExpression, error) {
+	if n.input == "" {
+		return nil, n.errorf(0, "empty expression")
+	}
+
+	expr := &DiceExpression{}
+
+	count, ok, err := n.number()
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		count = 1
+	}
+
+	if count < 1 {
+		return nil, n.errorf(0, "must roll at least one die")
+	}
+
+	expr.Count = count
+
+	if strings.HasPrefix(n.input[n.pos:], "cd") {
+		n.pos += 2
+
+		return n.parseChallenge(expr)
+	}
+
+	if n.peek() != 'd' {
+		return nil, n.errorf(n.pos, "expected 'd'")
+	}
+
+	n.pos++
+	sidesPos := n.pos
+
+	if expr.Sides, err = n.requireNumber("number of sides"); err != nil {
+		return nil, err
+	}
+
+	if expr.Sides < 1 {
+		return nil, n.errorf(sidesPos, "dice must have at least one side")
+	}
+
+	for n.pos < len(n.input) {
+		if err := n.parseSuffix(expr); err != nil {
+			return nil, err
+		}
+	}
+
+	// a die above the target is a failure, so it can't be a crit either
+	if expr.Target > 0 && expr.Focus > expr.Target {
+		return nil, n.errorf(n.focusPos, "focus can't be above the target number %d", expr.Target)
+	}
+
+	return expr, nil
+}
+
+func (n *notationParser) parseChallenge(expr *DiceExpression) (*DiceExpression, error) {
+	expr.Challenge = true
+	expr.Sides = 6
+
+	for n.pos < len(n.input) {
+		switch n.peek() {
+		case '+', '-':
+			if err := n.parseSuffix(expr); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, n.errorf(n.pos, "challenge dice only accept modifiers")
+		}
+	}
+
+	return expr, nil
+}
+
+func (n *notationParser) parseSuffix(expr *DiceExpression) error {
+	start := n.pos
+	op := n.input[n.pos]
+	n.pos++
+
+	switch op {
+	case '+', '-':
+		value, err := n.requireNumber("modifier")
+		if err != nil {
+			return err
+		}
+
+		if op == '-' {
+			value = -value
+		}
+
+		expr.Modifier += value
+
+		return nil
+	case 't', 'f', 'c':
+		return n.parseThreshold(expr, op, start)
+	case 'k', 'd':
+		return n.parseKeep(expr, op, start)
+	}
+
+	return n.errorf(start, "unexpected %q", op)
+}
+
+func (n *notationParser) parseThreshold(expr *DiceExpression, op byte, start int) error {
+	value, err := n.requireNumber(fmt.Sprintf("number after %q", op))
+	if err != nil {
+		return err
+	}
+
+	if value < 1 || value > expr.Sides {
+		return n.errorf(start+1, "%q must be between 1 and %d", op, expr.Sides)
+	}
+
+	var field *int
+
+	switch op {
+	case 't':
+		field = &expr.Target
+	case 'f':
+		field = &expr.Focus
+		n.focusPos = start
+	case 'c':
+		field = &expr.ComplicationRange
+	}
+
+	if *field != 0 {
+		return n.errorf(start, "%q given more than once", op)
+	}
+
+	*field = value
+
+	return nil
+}
+
+func (n *notationParser) parseKeep(expr *DiceExpression, op byte, start int) error {
+	if expr.Keep != 0 || expr.Drop != 0 {
+		return n.errorf(start, "only one keep or drop option is allowed")
+	}
+
+	// "k" and "d" default to keep highest and drop lowest respectively
+	highest := op == 'k'
+
+	switch n.peek() {
+	case 'h':
+		highest = true
+		n.pos++
+	case 'l':
+		highest = false
+		n.pos++
+	}
+
+	numPos := n.pos
+
+	value, err := n.requireNumber("number of dice to keep or drop")
+	if err != nil {
+		return err
+	}
+
+	if op == 'k' {
+		if value < 1 || value > expr.Count {
+			return n.errorf(numPos, "can only keep between 1 and %d dice", expr.Count)
+		}
+
+		expr.Keep = value
+		expr.KeepLowest = !highest
+
+		return nil
+	}
+
+	if value < 1 || value >= expr.Count {
+		return n.errorf(numPos, "can only drop between 1 and %d dice", expr.Count-1)
+	}
+
+	// dropping the highest keeps the lowest
+	expr.Drop = value
+	expr.KeepLowest = highest
+
+	return nil
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseDiceNotation(t *testing.T) {
+	tests := []struct {
+		input string
+		want  *DiceExpression
+	}{
+		{"2d20", &DiceExpression{Count: 2, Sides: 20}},
+		{"d6", &DiceExpression{Count: 1, Sides: 6}},
+		{" 2 D20 T10 ", &DiceExpression{Count: 2, Sides: 20, Target: 10}},
+		{"3d6+2-1", &DiceExpression{Count: 3, Sides: 6, Modifier: 1}},
+		{"4d20t12f3", &DiceExpression{Count: 4, Sides: 20, Target: 12, Focus: 3}},
+		{"2d20f3t12", &DiceExpression{Count: 2, Sides: 20, Target: 12, Focus: 3}},
+		{"2d20t10f10", &DiceExpression{Count: 2, Sides: 20, Target: 10, Focus: 10}},
+		{"2d20c2", &DiceExpression{Count: 2, Sides: 20, ComplicationRange: 2}},
+		{"4d20k2", &DiceExpression{Count: 4, Sides: 20, Keep: 2}},
+		{"4d20kh2", &DiceExpression{Count: 4, Sides: 20, Keep: 2}},
+		{"4d20kl1", &DiceExpression{Count: 4, Sides: 20, Keep: 1, KeepLowest: true}},
+		{"4d20d1", &DiceExpression{Count: 4, Sides: 20, Drop: 1}},
+		{"4d20dl1", &DiceExpression{Count: 4, Sides: 20, Drop: 1}},
+		{"4d20dh1", &DiceExpression{Count: 4, Sides: 20, Drop: 1, KeepLowest: true}},
+		{"3cd", &DiceExpression{Challenge: true, Count: 3, Sides: 6}},
+		{"3cd+1", &DiceExpression{Challenge: true, Count: 3, Sides: 6, Modifier: 1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			got, err := ParseDiceNotation(test.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseDiceNotationErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		position int
+		message  string
+	}{
+		{"", 0, "empty expression"},
+		{"2x20", 1, "expected 'd'"},
+		{"0d20", 0, "must roll at least one die"},
+		{"2d", 2, "expected number of sides"},
+		{"2d0", 2, "dice must have at least one side"},
+		{"2d20t21", 5, `'t' must be between 1 and 20`},
+		{"2d20t5t6", 6, `'t' given more than once`},
+		{"2d20f5t3", 4, "focus can't be above the target number 3"},
+		{"2d20t3f5", 6, "focus can't be above the target number 3"},
+		{"2d20kh1d1", 7, "only one keep or drop option is allowed"},
+		{"2d20k3", 5, "can only keep between 1 and 2 dice"},
+		{"2d20d2", 5, "can only drop between 1 and 1 dice"},
+		{"2d20x", 4, `unexpected 'x'`},
+		{"3cdt2", 3, "challenge dice only accept modifiers"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			_, err := ParseDiceNotation(test.input)
+
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("got %v, want a parse error", err)
+			}
+
+			if parseErr.Position != test.position || parseErr.Message != test.message {
+				t.Errorf("got %q at %d, want %q at %d", parseErr.Message, parseErr.Position, test.message,
+					test.position)
+			}
+		})
+	}
+}
+
+func TestDiceExpressionString(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"d20", "1d20"},
+		{"2d20-2", "2d20-2"},
+		{"2d20f3t12c2", "2d20t12f3c2"},
+		{"4d20k2", "4d20kh2"},
+		{"4d20kl1", "4d20kl1"},
+		{"4d20d1", "4d20dl1"},
+		{"4d20dh1", "4d20dh1"},
+		{"3cd+1", "3cd+1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			expr, err := ParseDiceNotation(test.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := expr.String(); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+
+			// the history keeps the string, and rerolls parse it again
+			reparsed, err := ParseDiceNotation(expr.String())
+			if err != nil {
+				t.Fatalf("failed to parse %q again: %v", expr.String(), err)
+			}
+
+			if !reflect.DeepEqual(reparsed, expr) {
+				t.Errorf("got %+v after parsing again, want %+v", reparsed, expr)
+			}
+		})
+	}
+}
+
+func TestBuyDiceKeepsDropCount(t *testing.T) {
+	tests := []struct {
+		input string
+		buy   int
+		want  string
+	}{
+		{"2d20t10", 1, "3d20t10"},
+		{"3d20d1", 2, "5d20dl1"},
+		{"3d20dh1", 3, "6d20dh1"},
+		{"3d20kh2", 2, "5d20kh2"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			expr, err := ParseDiceNotation(test.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := expr.BuyDice(test.buy); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := expr.String(); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDropDice(t *testing.T) {
+	determination := DieResult{Value: 1, Crit: true, Determination: true}
+
+	tests := []struct {
+		name    string
+		input   string
+		values  []int
+		last    *DieResult // replaces the last die, like Determination does
+		dropped []bool
+	}{
+		{"keep every die", "3d20", []int{5, 12, 3}, nil, []bool{false, false, false}},
+		{"keep highest", "3d20kh2", []int{5, 12, 3}, nil, []bool{false, false, true}},
+		{"keep lowest", "3d20kl1", []int{5, 12, 3}, nil, []bool{true, true, false}},
+		{"drop lowest", "3d20d1", []int{5, 12, 3}, nil, []bool{false, false, true}},
+		{"drop highest", "3d20dh1", []int{5, 12, 3}, nil, []bool{false, true, false}},
+		{"drop lowest of bought dice", "5d20d1", []int{5, 12, 3, 9, 7}, nil, []bool{false, false, true, false, false}},
+		{"keep Determination die", "4d20kh2", []int{11, 4, 16, 0}, &determination, []bool{false, true, false, false}},
+		{"drop around Determination", "4d20d1", []int{11, 4, 16, 0}, &determination, []bool{false, true, false, false}},
+		{"keep lowest with Determination", "4d20kl1", []int{11, 4, 16, 0}, &determination, []bool{true, false, true, false}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expr, err := ParseDiceNotation(test.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			result := make(DiceResults, len(test.values))
+			for i, value := range test.values {
+				result[i] = DieResult{Value: value}
+			}
+
+			if test.last != nil {
+				result[len(result)-1] = *test.last
+			}
+
+			expr.dropDice(result)
+
+			for i, dieResult := range result {
+				if dieResult.Dropped != test.dropped[i] {
+					t.Errorf("die %d (%d): got dropped %t, want %t", i, dieResult.Value, dieResult.Dropped,
+						test.dropped[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDeterminationIsAlwaysKept(t *testing.T) {
+	for range 100 {
+		expr, err := ParseDiceNotation("3d20t10kh2")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if err := expr.AddDetermination(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		roll := expr.Roll(&User{})
+		last := roll.Result[len(roll.Result)-1]
+
+		if !last.Determination || last.Dropped {
+			t.Fatalf("got %+v, want a kept Determination die", last)
+		}
+
+		if roll.Task.Successes < 2 {
+			t.Fatalf("got %d successes from %s, want at least the Determination die's 2", roll.Task.Successes,
+				roll.Result)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name   string
+		result DiceResults
+		task   TaskOpts
+		want   TaskResult
+	}{
+		{
+			name:   "one success",
+			result: DiceResults{{Value: 9}, {Value: 15}},
+			task:   TaskOpts{Target: 10, Focus: 1, Difficulty: 1},
+			want:   TaskResult{Target: 10, Difficulty: 1, Successes: 1, Passed: true},
+		},
+		{
+			name:   "crits count twice",
+			result: DiceResults{{Value: 1}, {Value: 3}},
+			task:   TaskOpts{Target: 10, Focus: 3, Difficulty: 2},
+			want:   TaskResult{Target: 10, Difficulty: 2, Successes: 4, Passed: true, Momentum: 2},
+		},
+		{
+			name:   "failure",
+			result: DiceResults{{Value: 11}, {Value: 20, Complication: true}},
+			task:   TaskOpts{Target: 10, Focus: 1, Difficulty: 1},
+			want:   TaskResult{Target: 10, Difficulty: 1, Complications: 1},
+		},
+		{
+			name:   "difficulty 0 passes without successes",
+			result: DiceResults{{Value: 18}, {Value: 19}},
+			task:   TaskOpts{Target: 10, Focus: 1},
+			want:   TaskResult{Target: 10, Passed: true},
+		},
+		{
+			name:   "dropped dice don't count",
+			result: DiceResults{{Value: 2}, {Value: 1, Dropped: true}, {Value: 20, Complication: true, Dropped: true}},
+			task:   TaskOpts{Target: 10, Focus: 1, Difficulty: 1},
+			want:   TaskResult{Target: 10, Difficulty: 1, Successes: 1, Passed: true},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			roll := Roll{Result: test.result}
+			roll.Resolve(&test.task)
+
+			if !reflect.DeepEqual(*roll.Task, test.want) {
+				t.Errorf("got %+v, want %+v", *roll.Task, test.want)
+			}
+		})
+	}
+}
+
+func TestBoughtDiceCost(t *testing.T) {
+	tests := []struct {
+		num  int
+		want int
+	}{
+		{0, 0},
+		{1, 1},
+		{2, 3},
+		{3, 6},
+	}
+
+	for _, test := range tests {
+		if got := BoughtDiceCost(test.num); got != test.want {
+			t.Errorf("BoughtDiceCost(%d) = %d, want %d", test.num, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// NPCKind is how much an NPC matters to the story, which decides whether they can take Stress.
+type NPCKind string
+
+const (
+	NPCMinor   NPCKind = "minor"
+	NPCNotable NPCKind = "notable"
+	NPCMajor   NPCKind = "major"
+)
+
+var NPCKinds = []NPCKind{NPCMinor, NPCNotable, NPCMajor}
+
+// NPC is a stat block in the Game Master's roster. Players never see it, only the NPC's name on public rolls.
+type NPC struct {
+	ID          int            `json:"id"`
+	Name        string         `json:"name"`
+	Kind        NPCKind        `json:"kind"`
+	Attributes  map[string]int `json:"attributes"`
+	Disciplines map[string]int `json:"disciplines"`
+	Focuses     []string       `json:"focuses"`
+	Stress      int            `json:"stress"`
+}
+
+// MaxStress is Fitness plus Security for notable and major NPCs. Minor NPCs have no Stress track, so any Injury
+// defeats them.
+func (n NPC) MaxStress() int {
+	if n.Kind == NPCMinor {
+		return 0
+	}
+
+	return n.Attributes["Fitness"] + n.Disciplines["Security"]
+}
+
+// Task returns the target number and crit range for the NPC's attribute, discipline and focus, like a character
+// sheet's.
+func (n NPC) Task(attribute, discipline, focus string) (int, int, error) {
+	sheet := &CharacterSheet{
+		Character:   n.Name,
+		Attributes:  n.Attributes,
+		Disciplines: n.Disciplines,
+		Focuses:     n.Focuses,
+	}
+
+	return sheet.Task(attribute, discipline, focus)
+}
+
+func (s *Stats) AddNPC(npc NPC) NPC {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	npc.ID = 1
+	for _, existing := range s.NPCs {
+		npc.ID = max(npc.ID, existing.ID+1)
+	}
+
+	s.NPCs = append(s.NPCs, npc)
+
+	return npc
+}
+
+// RemoveNPC reports whether there was an NPC with id to remove.
+func (s *Stats) RemoveNPC(id int) bool {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	before := len(s.NPCs)
+	s.NPCs = slices.DeleteFunc(s.NPCs, func(npc NPC) bool { return npc.ID == id })
+
+	return len(s.NPCs) != before
+}
+
+func (s *Stats) NPC(id int) (NPC, bool) {
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+
+	for _, npc := range s.NPCs {
+		if npc.ID == id {
+			return npc, true
+		}
+	}
+
+	return NPC{}, false
+}
+
+// Roster returns a copy of every NPC.
+func (s *Stats) Roster() []NPC {
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+
+	return slices.Clone(s.NPCs)
+}
+
+func (s *Stats) SetNPCStress(id, stress int) error {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	index := slices.IndexFunc(s.NPCs, func(npc NPC) bool { return npc.ID == id })
+	if index < 0 {
+		return notFoundErr("There is no NPC #%d.", id)
+	}
+
+	npc := &s.NPCs[index]
+
+	switch maxStress := npc.MaxStress(); {
+	case maxStress == 0:
+		return validationErr("%s is a minor NPC and has no Stress track.", npc.Name)
+	case stress < 0 || stress > maxStress:
+		return validationErr("%s's Stress must be between 0 and %d.", npc.Name, maxStress)
+	}
+
+	npc.Stress = stress
+
+	return nil
+}
+
+// RollNPC rolls expr for npc on the Game Master's behalf, with dice extra d20s bought with Threat. Public rolls go into
+// the history under the NPC's name; private ones are only returned, and the ledger doesn't say who they were for. The
+// Threat is given back if a public roll can't be saved.
+func (r *Room) RollNPC(user *User, npc NPC, expr *DiceExpression, dice int, private bool) (Roll, error) {
+	cost := BoughtDiceCost(dice)
+
+	if cost > 0 {
+		if err := r.Stats.SpendThreat(cost); err != nil {
+			return Roll{}, err
+		}
+	}
+
+	npcUser := *user
+	npcUser.CharacterName = npc.Name
+
+	roll := expr.Roll(&npcUser)
+
+	if !private {
+		if err := r.addRoll(&roll); err != nil {
+			r.Stats.AddThreat(cost)
+			return roll, internalErr(err, "failed to save roll")
+		}
+
+		r.NotifyRoll(roll)
+	}
+
+	if cost > 0 {
+		// everybody sees the ledger, so it mustn't give away who made a private roll
+		reason := fmt.Sprintf("Bought %d d20s for %s", dice, npc.Name)
+		if private {
+			reason = fmt.Sprintf("Bought %d d20s for a private roll", dice)
+		}
+
+		transaction := Transaction{
+			Kind:   TransactionNPCDice,
+			User:   user,
+			Reason: reason,
+			Threat: -cost,
+			Dice:   dice,
+			RollID: roll.ID,
+		}
+
+		if _, err := r.recordTransaction(transaction); err != nil {
+			return roll, internalErr(err, "failed to save transaction")
+		}
+	}
+
+	return roll, nil
+}
+
+// npcDice reads the attribute, discipline, focus, difficulty and extra dice for npc's roll from the form.
+func npcDice(npc NPC, form url.Values) (*DiceExpression, int, error) {
+	target, critOn, err := npc.Task(form.Get("attribute"), form.Get("discipline"), form.Get("focus"))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	expr := &DiceExpression{Count: 2, Sides: 20, Target: target, Focus: critOn}
+
+	if rawDifficulty := form.Get("difficulty"); rawDifficulty != "" {
+		expr.Difficulty, err = strconv.Atoi(rawDifficulty)
+		if err != nil || expr.Difficulty < 0 {
+			return nil, 0, validationErr("Difficulty must be a whole number of at least 0.")
+		}
+	}
+
+	dice := 0
+
+	if rawDice := form.Get("buy-dice"); rawDice != "" {
+		dice, err = strconv.Atoi(rawDice)
+		if err != nil || dice < 0 || dice > MaxBoughtDice {
+			return nil, 0, validationErr("NPCs can buy up to %d extra d20s.", MaxBoughtDice)
+		}
+	}
+
+	expr.Count += dice
+
+	return expr, dice, nil
+}
+
+func npcFromForm(form url.Values) (NPC, error) {
+	npc := NPC{
+		Name:    strings.TrimSpace(form.Get("name")),
+		Kind:    NPCKind(form.Get("kind")),
+		Focuses: splitList(form.Get("focuses")),
+	}
+
+	if npc.Name == "" {
+		return npc, validationErr("Name the NPC.")
+	}
+
+	if !slices.Contains(NPCKinds, npc.Kind) {
+		return npc, validationErr("NPCs are minor, notable or major.")
+	}
+
+	attributes, disciplines, err := scoresFromForm(form)
+	if err != nil {
+		return npc, err
+	}
+
+	npc.Attributes = attributes
+	npc.Disciplines = disciplines
+
+	return npc, nil
+}
+
+// npcFromPath finds the NPC whose ID is in the path.
+func npcFromPath(req *http.Request) (NPC, error) {
+	id, err := strconv.Atoi(req.PathValue("id"))
+	if err != nil {
+		return NPC{}, validationErr("NPC must be a number.")
+	}
+
+	npc, ok := RoomFromContext(req).Stats.NPC(id)
+	if !ok {
+		return NPC{}, notFoundErr("There is no NPC #%d.", id)
+	}
+
+	return npc, nil
+}
+
+func (s *Server) NPCRosterHandler(writer http.ResponseWriter, req *http.Request) {
+	s.renderNPCRoster(writer, req)
+}
+
+func (s *Server) AddNPCHandler(writer http.ResponseWriter, req *http.Request) {
+	room := RoomFromContext(req)
+
+	if err := req.ParseForm(); err != nil {
+		s.handleErr(writer, req, validationErr("Failed to parse form: %v", err))
+		return
+	}
+
+	npc, err := npcFromForm(req.Form)
+	if err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	room.Stats.AddNPC(npc)
+
+	if err := room.saveStats(); err != nil {
+		s.handleErr(writer, req, internalErr(err, "failed to save stats"))
+		return
+	}
+
+	s.renderNPCRoster(writer, req)
+}
+
+func (s *Server) RemoveNPCHandler(writer http.ResponseWriter, req *http.Request) {
+	room := RoomFromContext(req)
+
+	npc, err := npcFromPath(req)
+	if err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	room.Stats.RemoveNPC(npc.ID)
+
+	if err := room.saveStats(); err != nil {
+		s.handleErr(writer, req, internalErr(err, "failed to save stats"))
+		return
+	}
+
+	s.renderNPCRoster(writer, req)
+}
+
+func (s *Server) NPCStressHandler(writer http.ResponseWriter, req *http.Request) {
+	room := RoomFromContext(req)
+
+	npc, err := npcFromPath(req)
+	if err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	stress, err := strconv.Atoi(req.FormValue("stress"))
+	if err != nil {
+		s.handleErr(writer, req, validationErr("Stress must be a whole number."))
+		return
+	}
+
+	if err := room.Stats.SetNPCStress(npc.ID, stress); err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	if err := room.saveStats(); err != nil {
+		s.handleErr(writer, req, internalErr(err, "failed to save stats"))
+		return
+	}
+
+	s.renderNPCRoster(writer, req)
+}
+
+// NPCRollHandler rolls for an NPC. Public rolls reach the Game Master through SSE like everybody else's; private ones
+// are shown to them straight away.
+func (s *Server) NPCRollHandler(writer http.ResponseWriter, req *http.Request) {
+	user := UserFromContext(req)
+	room := RoomFromContext(req)
+
+	npc, err := npcFromPath(req)
+	if err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	if err := req.ParseForm(); err != nil {
+		s.handleErr(writer, req, validationErr("Failed to parse form: %v", err))
+		return
+	}
+
+	expr, dice, err := npcDice(npc, req.Form)
+	if err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	if err := s.Opts.Config.DiceLimits.Check(expr); err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	private := req.Form.Get("visibility") == "private"
+
+	roll, err := room.RollNPC(user, npc, expr, dice, private)
+	if err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	if !private {
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if err := s.Renderer.ExecuteSingle(writer, "private_roll", roll); err != nil {
+		s.handleErr(writer, req, internalErr(err, "failed to execute private roll template"))
+		return
+	}
+}
+
+func (s *Server) renderNPCRoster(writer http.ResponseWriter, req *http.Request) {
+	room := RoomFromContext(req)
+
+	data := struct {
+		Room        *Room
+		NPCs        []NPC
+		Kinds       []NPCKind
+		Attributes  []string
+		Disciplines []string
+	}{
+		Room:        room,
+		NPCs:        room.Stats.Roster(),
+		Kinds:       NPCKinds,
+		Attributes:  Attributes,
+		Disciplines: Disciplines,
+	}
+
+	if err := s.Renderer.ExecuteSingle(writer, "npc_roster", data); err != nil {
+		s.handleErr(writer, req, internalErr(err, "failed to execute NPC roster template"))
+		return
+	}
+}
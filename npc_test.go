@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestNPCRollVisibility(t *testing.T) {
+	tests := []struct {
+		visibility string
+		status     int
+		reason     string
+		shown      bool // whether players learn who rolled
+	}{
+		{visibility: "public", status: http.StatusNoContent, reason: "Bought 2 d20s for Gul Dukat", shown: true},
+		{visibility: "private", status: http.StatusOK, reason: "Bought 2 d20s for a private roll"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.visibility, func(t *testing.T) {
+			server := newTestServer(t)
+			room := server.Rooms[defaultRoomName]
+			gm := testGameMaster(server)
+			kirk := testPlayer("p", "Kirk")
+
+			room.Stats.AddThreat(5)
+
+			npc := room.Stats.AddNPC(NPC{
+				Name:        "Gul Dukat",
+				Kind:        NPCMajor,
+				Attributes:  map[string]int{"Daring": 10},
+				Disciplines: map[string]int{"Command": 4},
+			})
+
+			client := listen(room, kirk)
+
+			form := "attribute=Daring&discipline=Command&buy-dice=2&visibility=" + test.visibility
+
+			recorder := serve(t, server, gm, "POST", room.URL(fmt.Sprintf("npcs/%d/roll", npc.ID)), form)
+			wantStatus(t, recorder, test.status)
+
+			if room.Stats.Threat != 2 {
+				t.Errorf("got Threat %d, want 2 after buying 2 d20s", room.Stats.Threat)
+			}
+
+			transactions := room.TransactionLog(false)
+			if len(transactions) != 1 || transactions[0].Reason != test.reason {
+				t.Fatalf("got %+v in the ledger, want one entry for %q", transactions, test.reason)
+			}
+
+			if !test.shown && transactions[0].RollID != 0 {
+				t.Errorf("got roll #%d in the ledger for a private roll", transactions[0].RollID)
+			}
+
+			sent := received(client)
+			if !strings.Contains(sent, test.reason) {
+				t.Errorf("player was sent %q, want the ledger entry", sent)
+			}
+
+			ledger := serve(t, server, kirk, "GET", room.URL("transactions"), nil).Body.String()
+
+			for _, seen := range []string{sent, ledger} {
+				if strings.Contains(seen, npc.Name) != test.shown {
+					t.Errorf("got %q, want the NPC's name shown %t", seen, test.shown)
+				}
+			}
+		})
+	}
+}
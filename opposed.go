@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// gameMasterOpponent is the opponent form value for challenging the Game Master.
+const gameMasterOpponent = "game-master"
+
+// OpposedResult is the defender's side of an opposed task, kept on the challenger's roll in the history.
+type OpposedResult struct {
+	Opponent Roll   `json:"opponent"`
+	Winner   *User  `json:"winner,omitempty"` // nil if neither side succeeded
+	Reason   string `json:"reason"`
+}
+
+// OpposedTask is a challenge waiting for the defender to roll. The challenger's roll is kept hidden until then. Each
+// room has at most one open.
+type OpposedTask struct {
+	Challenger     *User
+	ChallengerRoll Roll
+	Defender       string // character name, unless DefenderIsGameMaster
+	Opened         time.Time
+
+	DefenderIsGameMaster bool
+}
+
+// DefenderName is who the challenger is up against.
+func (o *OpposedTask) DefenderName() string {
+	if o.DefenderIsGameMaster {
+		return "the Game Master"
+	}
+
+	return o.Defender
+}
+
+// IsDefender reports whether user is the one who has to answer the challenge.
+func (o *OpposedTask) IsDefender(user *User) bool {
+	if o.DefenderIsGameMaster {
+		return user.IsGameMaster
+	}
+
+	return !user.IsGameMaster && user.CharacterName == o.Defender
+}
+
+// resolveOpposed decides who wins an opposed task. A side that passes its task beats one that doesn't, then the side
+// with more Momentum wins, so successes spent overcoming a higher Difficulty don't count. Ties go to the defender. If
+// neither side passes, nobody wins.
+func resolveOpposed(challenger, defender Roll) (*User, string) {
+	challengerPassed := challenger.Task != nil && challenger.Task.Passed
+	defenderPassed := defender.Task != nil && defender.Task.Passed
+
+	switch {
+	case !challengerPassed && !defenderPassed:
+		return nil, "both failed"
+	case challengerPassed && !defenderPassed:
+		return challenger.User, "only " + challenger.User.CharacterName + " succeeded"
+	case !challengerPassed && defenderPassed:
+		return defender.User, "only " + defender.User.CharacterName + " succeeded"
+	case challenger.Task.Momentum > defender.Task.Momentum:
+		return challenger.User, "more Momentum"
+	case challenger.Task.Momentum < defender.Task.Momentum:
+		return defender.User, "more Momentum"
+	}
+
+	return defender.User, "ties go to the defender"
+}
+
+// OpposedTask returns a copy of the open opposed task, or nil if there isn't one.
+func (r *Room) OpposedTask() *OpposedTask {
+	r.opposedMutex.Lock()
+	defer r.opposedMutex.Unlock()
+
+	if r.opposed == nil {
+		return nil
+	}
+
+	task := *r.opposed
+
+	return &task
+}
+
+// OpenOpposedTask rolls user's side of an opposed task against opponent, which is a character name or
+// gameMasterOpponent.
+func (r *Room) OpenOpposedTask(user *User, opponent string, expr *DiceExpression) error {
+	if expr.Challenge || expr.Target == 0 {
+		return validationErr("An opposed task needs a target number.")
+	}
+
+	task := &OpposedTask{
+		Challenger: user,
+		Defender:   opponent,
+		Opened:     time.Now(),
+
+		DefenderIsGameMaster: opponent == gameMasterOpponent,
+	}
+
+	switch {
+	case task.DefenderIsGameMaster && user.IsGameMaster:
+		return validationErr("You can't challenge yourself.")
+	case !task.DefenderIsGameMaster && !slices.Contains(r.Characters(), opponent):
+		return validationErr("Choose who to challenge.")
+	case task.IsDefender(user):
+		return validationErr("You can't challenge yourself.")
+	}
+
+	task.ChallengerRoll = expr.Roll(user)
+
+	r.opposedMutex.Lock()
+
+	if r.opposed != nil {
+		challenger := r.opposed.Challenger.CharacterName
+		r.opposedMutex.Unlock()
+
+		return validationErr("%s is already in an opposed task.", challenger)
+	}
+
+	r.opposed = task
+	r.opposedMutex.Unlock()
+
+	r.NotifyOpposed(user.CharacterName + " challenged " + task.DefenderName() + " to an opposed task.")
+
+	return nil
+}
+
+// RespondOpposedTask rolls the defender's side of the open opposed task and adds both rolls to the history as one
+// entry.
+func (r *Room) RespondOpposedTask(user *User, expr *DiceExpression) (Roll, error) {
+	if expr.Challenge || expr.Target == 0 {
+		return Roll{}, validationErr("An opposed task needs a target number.")
+	}
+
+	r.opposedMutex.Lock()
+
+	task := r.opposed
+
+	switch {
+	case task == nil:
+		r.opposedMutex.Unlock()
+		return Roll{}, notFoundErr("There is no opposed task to answer.")
+	case !task.IsDefender(user):
+		r.opposedMutex.Unlock()
+		return Roll{}, forbiddenErr("Only %s can answer this challenge.", task.DefenderName())
+	}
+
+	r.opposed = nil
+	r.opposedMutex.Unlock()
+
+	roll := task.ChallengerRoll
+	roll.Time = time.Now()
+
+	opponent := expr.Roll(user)
+	opponent.Time = roll.Time
+
+	winner, reason := resolveOpposed(roll, opponent)
+	roll.Opposed = &OpposedResult{
+		Opponent: opponent,
+		Winner:   winner,
+		Reason:   reason,
+	}
+
+	// Momentum from opposed tasks is left to the Game Master, since only the winner's excess counts
+	if err := r.addRoll(&roll); err != nil {
+		return roll, err
+	}
+
+	r.NotifyRoll(roll)
+
+	if winner != nil {
+		r.NotifyOpposed(winner.CharacterName + " won the opposed task (" + reason + ").")
+	} else {
+		r.NotifyOpposed("Nobody won the opposed task between " + task.Challenger.CharacterName + " and " +
+			task.DefenderName() + " (" + reason + ").")
+	}
+
+	return roll, nil
+}
+
+// CancelOpposedTask closes the open opposed task without rolling the defender's side. Either side and the Game
+// Master can cancel it.
+func (r *Room) CancelOpposedTask(user *User) error {
+	r.opposedMutex.Lock()
+
+	task := r.opposed
+
+	switch {
+	case task == nil:
+		r.opposedMutex.Unlock()
+		return notFoundErr("There is no opposed task to cancel.")
+	case !user.IsGameMaster && !task.Challenger.Same(user) && !task.IsDefender(user):
+		r.opposedMutex.Unlock()
+		return forbiddenErr("Only the two sides or the Game Master can cancel this challenge.")
+	}
+
+	r.opposed = nil
+	r.opposedMutex.Unlock()
+
+	r.NotifyOpposed(task.Challenger.CharacterName + "'s challenge was cancelled.")
+
+	return nil
+}
+
+// NotifyOpposed tells every client that the opposed task changed, so they can reload their view of it.
+func (r *Room) NotifyOpposed(message string) {
+	var buf bytes.Buffer
+
+	if err := r.renderer.ExecuteSingle(&buf, "opposed_notice", message); err != nil {
+		log.Printf("Error rendering opposed task notice: %v", err)
+		return
+	}
+
+	r.broadcast(EventMessage{
+		EventType: EventTypeOpposed,
+		Data:      buf.Bytes(),
+	})
+}
+
+func (s *Server) OpposedTaskHandler(writer http.ResponseWriter, req *http.Request) {
+	s.renderOpposedTask(writer, req)
+}
+
+func (s *Server) OpenOpposedTaskHandler(writer http.ResponseWriter, req *http.Request) {
+	user := UserFromContext(req)
+	room := RoomFromContext(req)
+
+	expr, err := s.opposedExpression(req)
+	if err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	if err := room.OpenOpposedTask(user, strings.TrimSpace(req.Form.Get("opponent")), expr); err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	s.renderOpposedTask(writer, req)
+}
+
+func (s *Server) RespondOpposedTaskHandler(writer http.ResponseWriter, req *http.Request) {
+	user := UserFromContext(req)
+	room := RoomFromContext(req)
+
+	expr, err := s.opposedExpression(req)
+	if err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	if _, err := room.RespondOpposedTask(user, expr); err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	s.renderOpposedTask(writer, req)
+}
+
+func (s *Server) CancelOpposedTaskHandler(writer http.ResponseWriter, req *http.Request) {
+	user := UserFromContext(req)
+	room := RoomFromContext(req)
+
+	if err := room.CancelOpposedTask(user); err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	s.renderOpposedTask(writer, req)
+}
+
+// opposedExpression reads one side's dice from the form and checks them against the dice limits.
+func (s *Server) opposedExpression(req *http.Request) (*DiceExpression, error) {
+	if err := req.ParseForm(); err != nil {
+		return nil, validationErr("Failed to parse form: %v", err)
+	}
+
+	expr, err := RoomFromContext(req).characterDice(UserFromContext(req), req.Form)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.Opts.Config.DiceLimits.Check(expr); err != nil {
+		return nil, err
+	}
+
+	return expr, nil
+}
+
+func (s *Server) renderOpposedTask(writer http.ResponseWriter, req *http.Request) {
+	user := UserFromContext(req)
+	room := RoomFromContext(req)
+	task := room.OpposedTask()
+
+	data := struct {
+		User       *User
+		Room       *Room
+		Task       *OpposedTask
+		Opponents  []string
+		Challenger bool
+		Defending  bool
+	}{
+		User: user,
+		Room: room,
+		Task: task,
+	}
+
+	if task != nil {
+		data.Challenger = task.Challenger.Same(user)
+		data.Defending = task.IsDefender(user)
+	} else {
+		for _, character := range room.Characters() {
+			if user.IsGameMaster || character != user.CharacterName {
+				data.Opponents = append(data.Opponents, character)
+			}
+		}
+	}
+
+	if err := s.Renderer.ExecuteSingle(writer, "opposed_task", data); err != nil {
+		s.handleErr(writer, req, internalErr(err, "failed to execute opposed task template"))
+		return
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import "testing"
+
+func TestResolveOpposed(t *testing.T) {
+	kirk := &User{Name: "p", CharacterName: "Kirk"}
+	spock := &User{Name: "q", CharacterName: "Spock"}
+
+	// roll resolves successes against difficulty like Roll.Resolve does
+	roll := func(user *User, successes, difficulty int) Roll {
+		task := &TaskResult{Target: 10, Difficulty: difficulty, Successes: successes}
+		task.Passed = successes >= difficulty
+
+		if task.Passed {
+			task.Momentum = successes - difficulty
+		}
+
+		return Roll{User: user, Task: task}
+	}
+
+	tests := []struct {
+		name       string
+		challenger Roll
+		defender   Roll
+		winner     *User
+		reason     string
+	}{
+		{"both fail", roll(kirk, 0, 1), roll(spock, 1, 2), nil, "both failed"},
+		{"only the challenger passes", roll(kirk, 1, 1), roll(spock, 1, 2), kirk, "only Kirk succeeded"},
+		{"only the defender passes", roll(kirk, 2, 3), roll(spock, 0, 0), spock, "only Spock succeeded"},
+		{"more Momentum", roll(kirk, 3, 1), roll(spock, 2, 1), kirk, "more Momentum"},
+		{"more successes but less Momentum", roll(kirk, 4, 3), roll(spock, 3, 1), spock, "more Momentum"},
+		{"fewer successes but more Momentum", roll(kirk, 2, 0), roll(spock, 3, 2), kirk, "more Momentum"},
+		{"same Momentum at different difficulties", roll(kirk, 4, 2), roll(spock, 2, 0), spock, "ties go to the defender"},
+		{"tie", roll(kirk, 2, 1), roll(spock, 2, 1), spock, "ties go to the defender"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			winner, reason := resolveOpposed(test.challenger, test.defender)
+
+			if winner != test.winner || reason != test.reason {
+				t.Errorf("got %v (%s), want %v (%s)", winner, reason, test.winner, test.reason)
+			}
+		})
+	}
+}
+
+func TestOpposedTask(t *testing.T) {
+	room := newTestRoom(t)
+	kirk := &User{Name: "p", CharacterName: "Kirk"}
+	spock := &User{Name: "q", CharacterName: "Spock"}
+	gm := &User{Name: "GM", IsGameMaster: true}
+
+	// only characters the room knows about can be challenged
+	room.Stats.SetCharacterSheet(&CharacterSheet{Character: "Spock", Owner: "q"})
+
+	want := func(err error, message string) {
+		t.Helper()
+
+		if mismatch := errMismatch(err, message); mismatch != "" {
+			t.Fatal(mismatch)
+		}
+	}
+
+	expr := &DiceExpression{Count: 2, Sides: 20, Target: 10, Difficulty: 1}
+
+	noTarget := &DiceExpression{Count: 2, Sides: 20}
+
+	want(room.OpenOpposedTask(kirk, "Spock", noTarget), "An opposed task needs a target number.")
+	want(room.OpenOpposedTask(kirk, "Kirk", expr), "Choose who to challenge.")
+	want(room.OpenOpposedTask(gm, gameMasterOpponent, expr), "You can't challenge yourself.")
+	want(room.OpenOpposedTask(kirk, "Spock", expr), "")
+	want(room.OpenOpposedTask(spock, gameMasterOpponent, expr), "Kirk is already in an opposed task.")
+
+	_, err := room.RespondOpposedTask(kirk, expr)
+	want(err, "Only Spock can answer this challenge.")
+
+	roll, err := room.RespondOpposedTask(spock, &DiceExpression{Count: 2, Sides: 20, Target: 12, Difficulty: 2})
+	want(err, "")
+
+	if roll.User != kirk || roll.Opposed == nil || roll.Opposed.Opponent.User != spock {
+		t.Fatalf("got %+v, want Kirk's roll linked to Spock's", roll)
+	}
+
+	if roll.Opposed.Opponent.Task.Difficulty != 2 {
+		t.Errorf("got Spock's difficulty %d, want 2", roll.Opposed.Opponent.Task.Difficulty)
+	}
+
+	if history := room.History(); len(history) != 1 {
+		t.Errorf("got %d rolls in the history, want one entry for both sides", len(history))
+	}
+
+	want(room.CancelOpposedTask(gm), "There is no opposed task to cancel.")
+}
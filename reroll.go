@@ -0,0 +1,305 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxRerolls   = 1
+	defaultRerollWindow = 15 * time.Minute
+)
+
+var defaultRerollReasons = []string{"Determination", "Talent"}
+
+// RerollRules decide which rolls can be rerolled, when and why.
+type RerollRules struct {
+	MaxRerolls *int     `json:"max_rerolls"` // how many times one roll can be rerolled; 0 turns rerolls off
+	MaxDice    int      `json:"max_dice"`    // how many dice one reroll can change; 0 allows every die
+	Window     string   `json:"window"`      // how long after the first roll rerolls are allowed, e.g. "15m"
+	Reasons    []string `json:"reasons"`     // what players can reroll with, e.g. "Determination"
+}
+
+// withDefaults fills in any rules that aren't configured.
+func (r *RerollRules) withDefaults() {
+	if r.MaxRerolls == nil {
+		maxRerolls := defaultMaxRerolls
+		r.MaxRerolls = &maxRerolls
+	}
+
+	if r.Window == "" {
+		r.Window = defaultRerollWindow.String()
+	}
+
+	if len(r.Reasons) == 0 {
+		r.Reasons = defaultRerollReasons
+	}
+}
+
+func (r *RerollRules) OK() error {
+	if *r.MaxRerolls < 0 {
+		return fmt.Errorf("max rerolls can't be negative")
+	}
+
+	if r.MaxDice < 0 {
+		return fmt.Errorf("max dice can't be negative")
+	}
+
+	if _, err := time.ParseDuration(r.Window); err != nil {
+		return fmt.Errorf("invalid window: %w", err)
+	}
+
+	return nil
+}
+
+// Enabled reports whether rolls can be rerolled at all.
+func (r *RerollRules) Enabled() bool {
+	return *r.MaxRerolls > 0
+}
+
+// WindowDuration is how long after the first roll rerolls are allowed.
+func (r *RerollRules) WindowDuration() time.Duration {
+	window, err := time.ParseDuration(r.Window)
+	if err != nil {
+		return defaultRerollWindow
+	}
+
+	return window
+}
+
+// Reroll rerolls the dice at indices in original, keeping the rest. Crits, complications, kept dice and the task are
+// all worked out again from the new values, and each rerolled die remembers what it was before.
+func (d *DiceExpression) Reroll(user *User, original Roll, indices []int) Roll {
+	roll := Roll{
+		Expression: original.Expression,
+		Modifier:   original.Modifier,
+		Time:       time.Now(),
+		User:       user,
+	}
+
+	if d.Challenge {
+		roll.Challenge = slices.Clone(original.Challenge)
+
+		for i := range roll.Challenge {
+			roll.Challenge[i].Rerolled, roll.Challenge[i].PreviousFace = false, 0
+		}
+
+		for _, index := range indices {
+			result := ChallengeDie{}.Roll()
+			result.Rerolled = true
+			result.PreviousFace = roll.Challenge[index].Face
+			roll.Challenge[index] = result
+		}
+
+		return roll
+	}
+
+	roll.Result = slices.Clone(original.Result)
+
+	for i := range roll.Result {
+		roll.Result[i].Dropped, roll.Result[i].Rerolled, roll.Result[i].Previous = false, false, 0
+	}
+	die := Die{Sides: d.Sides, CritOn: d.CritOn(), ComplicationOn: d.ComplicationOn()}
+
+	for _, index := range indices {
+		result := die.Roll()
+		result.Rerolled = true
+		result.Previous = roll.Result[index].Value
+		roll.Result[index] = result
+	}
+
+	d.dropDice(roll.Result)
+
+	roll.Assists = original.Assists
+	roll.Resolve(d.Task())
+
+	return roll
+}
+
+// Reroll rerolls some of roll id's dice for user, adding the result to the history as a new roll linked to the
+// original. Rerolling with Determination spends a point from the roller's character sheet, if they have one. Momentum
+// isn't added for rerolls; the group settles that through the ledger.
+func (r *Room) Reroll(user *User, rules *RerollRules, id int, indices []int, reason string) (Roll, error) {
+	// held until the reroll is in the history, so the same roll can't be rerolled twice at once
+	r.rollMutex.Lock()
+
+	if id < 1 || id > len(r.Rolls) {
+		r.rollMutex.Unlock()
+		return Roll{}, notFoundErr("There is no roll #%d.", id)
+	}
+
+	original := r.Rolls[id-1]
+
+	if err := r.checkReroll(user, rules, original, indices, reason); err != nil {
+		r.rollMutex.Unlock()
+		return Roll{}, err
+	}
+
+	expr, err := ParseDiceNotation(original.Expression)
+	if err != nil {
+		r.rollMutex.Unlock()
+		return Roll{}, internalErr(err, "failed to parse the expression of roll #%d", id)
+	}
+
+	if original.Task != nil {
+		expr.Difficulty = original.Task.Difficulty
+	}
+
+	// Determination is only tracked for characters with a sheet
+	spendDetermination := reason == determinationReason && original.User != nil &&
+		r.Stats.CharacterSheet(original.User.CharacterName) != nil
+
+	if spendDetermination {
+		if err := r.Stats.SpendDetermination(original.User.CharacterName); err != nil {
+			r.rollMutex.Unlock()
+			return Roll{}, err
+		}
+	}
+
+	roll := expr.Reroll(user, original, indices)
+	roll.RerollOf = id
+	roll.RerollReason = reason
+
+	err = r.appendRoll(&roll)
+	r.rollMutex.Unlock()
+
+	if err != nil {
+		if spendDetermination {
+			r.Stats.RefundDetermination(original.User.CharacterName)
+		}
+
+		return roll, err
+	}
+
+	r.NotifyRoll(roll)
+
+	if spendDetermination {
+		if err := r.saveStats(); err != nil {
+			return roll, err
+		}
+
+		r.NotifyClients(EventTypeStats)
+	}
+
+	return roll, nil
+}
+
+// checkReroll enforces the reroll rules. The caller must hold rollMutex.
+func (r *Room) checkReroll(user *User, rules *RerollRules, original Roll, indices []int, reason string) error {
+	switch {
+	case !rules.Enabled():
+		return validationErr("Rerolls are turned off.")
+	case original.Expression == "":
+		return validationErr("Roll #%d is too old to be rerolled.", original.ID)
+	case original.Opposed != nil:
+		return validationErr("Opposed tasks can't be rerolled.")
+	case original.ExtendedTask != nil:
+		return validationErr("Rolls for extended tasks can't be rerolled.")
+	case !user.IsGameMaster && !user.Same(original.User):
+		return forbiddenErr("You can only reroll your own rolls.")
+	case !slices.Contains(rules.Reasons, reason):
+		return validationErr("You can reroll with %s.", strings.Join(rules.Reasons, " or "))
+	}
+
+	// a reroll of a reroll counts against the first roll
+	first := original
+	for first.RerollOf != 0 {
+		first = r.Rolls[first.RerollOf-1]
+	}
+
+	rerolls := 0
+
+	for _, roll := range r.Rolls {
+		if roll.RerollOf == original.ID {
+			return validationErr("Roll #%d was already rerolled as #%d.", original.ID, roll.ID)
+		}
+
+		for linked := roll; linked.RerollOf != 0; linked = r.Rolls[linked.RerollOf-1] {
+			if linked.RerollOf == first.ID {
+				rerolls++
+				break
+			}
+		}
+	}
+
+	if rerolls >= *rules.MaxRerolls {
+		return validationErr("Roll #%d can't be rerolled again.", first.ID)
+	}
+
+	if time.Since(first.Time) > rules.WindowDuration() {
+		return validationErr("Rolls can only be rerolled within %s.", rules.WindowDuration())
+	}
+
+	size := len(original.Result) + len(original.Challenge)
+
+	switch {
+	case len(indices) == 0:
+		return validationErr("Choose which dice to reroll.")
+	case rules.MaxDice > 0 && len(indices) > rules.MaxDice:
+		return validationErr("You can reroll up to %d dice at once.", rules.MaxDice)
+	}
+
+	seen := map[int]bool{}
+
+	for _, index := range indices {
+		if index < 0 || index >= size {
+			return validationErr("Roll #%d only has %d dice.", original.ID, size)
+		}
+
+		if seen[index] {
+			return validationErr("Each die can only be rerolled once.")
+		}
+
+		if index < len(original.Result) && original.Result[index].Determination {
+			return validationErr("The die bought with Determination can't be rerolled.")
+		}
+
+		seen[index] = true
+	}
+
+	return nil
+}
+
+func (s *Server) RerollHandler(writer http.ResponseWriter, req *http.Request) {
+	user := UserFromContext(req)
+	room := RoomFromContext(req)
+
+	if err := req.ParseForm(); err != nil {
+		s.handleErr(writer, req, validationErr("Failed to parse form: %v", err))
+		return
+	}
+
+	id, err := strconv.Atoi(req.Form.Get("roll"))
+	if err != nil {
+		s.handleErr(writer, req, validationErr("Roll must be a roll number."))
+		return
+	}
+
+	// players count dice from 1, like they see them in the history
+	var indices []int
+
+	for _, part := range strings.Split(req.Form.Get("dice"), ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+
+		position, err := strconv.Atoi(part)
+		if err != nil {
+			s.handleErr(writer, req, validationErr("List the dice to reroll by position, e.g. \"1, 3\"."))
+			return
+		}
+
+		indices = append(indices, position-1)
+	}
+
+	if _, err := room.Reroll(user, s.Opts.Config.Rerolls, id, indices, req.Form.Get("reason")); err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRerollRules(t *testing.T) {
+	kirk := &User{Name: "p", CharacterName: "Kirk"}
+	spock := &User{Name: "q", CharacterName: "Spock"}
+	gm := &User{Name: "GM", IsGameMaster: true}
+
+	rules := func(maxRerolls, maxDice int) *RerollRules {
+		rules := &RerollRules{MaxRerolls: &maxRerolls, MaxDice: maxDice}
+		rules.withDefaults()
+
+		return rules
+	}
+
+	type attempt struct {
+		user    *User
+		id      int
+		indices []int
+		reason  string
+		wantErr string
+	}
+
+	tests := []struct {
+		name          string
+		rules         *RerollRules
+		determination bool          // Kirk spends Determination on the first roll
+		age           time.Duration // how long ago the first roll was made
+		attempts      []attempt
+	}{
+		{
+			name:     "turned off",
+			rules:    rules(0, 0),
+			attempts: []attempt{{kirk, 1, []int{0}, "Talent", "Rerolls are turned off."}},
+		},
+		{
+			name:  "once by default",
+			rules: rules(1, 0),
+			attempts: []attempt{
+				{kirk, 1, []int{0}, "Talent", ""},
+				{kirk, 1, []int{1}, "Talent", "Roll #1 was already rerolled as #2."},
+				{kirk, 2, []int{1}, "Talent", "Roll #1 can't be rerolled again."},
+			},
+		},
+		{
+			name:  "rerolls of rerolls count against the first roll",
+			rules: rules(2, 0),
+			attempts: []attempt{
+				{kirk, 1, []int{0}, "Talent", ""},
+				{kirk, 2, []int{0}, "Talent", ""},
+				{kirk, 3, []int{0}, "Talent", "Roll #1 can't be rerolled again."},
+			},
+		},
+		{
+			name:  "only your own rolls",
+			rules: rules(1, 0),
+			attempts: []attempt{
+				{spock, 1, []int{0}, "Talent", "You can only reroll your own rolls."},
+				{gm, 1, []int{0}, "Talent", ""},
+			},
+		},
+		{
+			name:  "reasons",
+			rules: rules(1, 0),
+			attempts: []attempt{
+				{kirk, 1, []int{0}, "Luck", "You can reroll with Determination or Talent."},
+				{kirk, 1, []int{0}, "", "You can reroll with Determination or Talent."},
+			},
+		},
+		{
+			name:  "which dice",
+			rules: rules(1, 2),
+			attempts: []attempt{
+				{kirk, 1, nil, "Talent", "Choose which dice to reroll."},
+				{kirk, 1, []int{0, 1, 2}, "Talent", "You can reroll up to 2 dice at once."},
+				{kirk, 1, []int{3}, "Talent", "Roll #1 only has 3 dice."},
+				{kirk, 1, []int{-1}, "Talent", "Roll #1 only has 3 dice."},
+				{kirk, 1, []int{1, 1}, "Talent", "Each die can only be rerolled once."},
+				{kirk, 9, []int{0}, "Talent", "There is no roll #9."},
+				{kirk, 1, []int{0, 2}, "Talent", ""},
+			},
+		},
+		{
+			name:          "not the Determination die",
+			rules:         rules(1, 0),
+			determination: true,
+			attempts: []attempt{
+				{kirk, 1, []int{3}, "Talent", "The die bought with Determination can't be rerolled."},
+				{kirk, 1, []int{0, 1, 2}, "Talent", ""},
+			},
+		},
+		{
+			name:     "too late",
+			rules:    rules(1, 0),
+			age:      16 * time.Minute,
+			attempts: []attempt{{kirk, 1, []int{0}, "Talent", "Rolls can only be rerolled within 15m0s."}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			room := newTestRoom(t)
+
+			expr, err := ParseDiceNotation("3d20t10")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if test.determination {
+				if err := expr.AddDetermination(); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			if _, err := room.Roll(kirk, expr, false); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			room.Rolls[0].Time = room.Rolls[0].Time.Add(-test.age)
+
+			for i, attempt := range test.attempts {
+				_, err := room.Reroll(attempt.user, test.rules, attempt.id, attempt.indices, attempt.reason)
+				if mismatch := errMismatch(err, attempt.wantErr); mismatch != "" {
+					t.Fatalf("attempt %d: %s", i, mismatch)
+				}
+			}
+		})
+	}
+}
+
+func TestRerollKeepsOtherDice(t *testing.T) {
+	room := newTestRoom(t)
+	kirk := &User{Name: "p", CharacterName: "Kirk"}
+
+	rules := &RerollRules{}
+	rules.withDefaults()
+
+	expr, err := ParseDiceNotation("3d20t10kh2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	original, err := room.Roll(kirk, expr, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	reroll, err := room.Reroll(kirk, rules, original.ID, []int{1}, "Talent")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if reroll.RerollOf != original.ID || reroll.RerollReason != "Talent" || reroll.Expression != original.Expression {
+		t.Errorf("got %+v, want a reroll of #%d for Talent", reroll, original.ID)
+	}
+
+	for i, result := range reroll.Result {
+		switch {
+		case i == 1 && (!result.Rerolled || result.Previous != original.Result[1].Value):
+			t.Errorf("die %d: got %+v, want it rerolled from %d", i, result, original.Result[1].Value)
+		case i != 1 && (result.Rerolled || result.Value != original.Result[i].Value):
+			t.Errorf("die %d: got %+v, want it kept at %d", i, result, original.Result[i].Value)
+		}
+	}
+
+	dropped := 0
+
+	for _, result := range reroll.Result {
+		if result.Dropped {
+			dropped++
+		}
+	}
+
+	if dropped != 1 || reroll.Task == nil || reroll.Task.Target != 10 {
+		t.Errorf("got %d dropped and task %+v, want the lowest die dropped and the task worked out again", dropped,
+			reroll.Task)
+	}
+}
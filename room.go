@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+const roomKey contextKey = "ROOM"
+
+// Room is one party's game: its own history, stats, Game Master and SSE clients.
+type Room struct {
+	Config       *RoomConfig
+	Rolls        Rolls
+	Stats        *Stats
+	Transactions []Transaction
+
+	renderer         *TemplateRenderer
+	store            Store
+	rollMutex        sync.Mutex
+	statsMutex       sync.Mutex
+	transactionMutex sync.Mutex
+	groupTaskMutex   sync.Mutex
+	groupTask        *GroupTask // not saved, so a restart closes any open group task
+	opposedMutex     sync.Mutex
+	opposed          *OpposedTask // not saved either
+	clientMutex      sync.RWMutex
+	clients          map[*sseClient]bool
+	events           *eventLog
+}
+
+func NewRoom(config *RoomConfig, store Store, renderer *TemplateRenderer) (*Room, error) {
+	state, err := store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load saved state: %w", err)
+	}
+
+	rolls, stats := state.Rolls, state.Stats
+
+	// rolls saved before IDs existed are numbered by their position
+	for i := range rolls {
+		if rolls[i].ID == 0 {
+			rolls[i].ID = i + 1
+		}
+	}
+
+	if stats == nil {
+		stats = &Stats{}
+	}
+
+	if stats.CharacterTraits == nil {
+		stats.CharacterTraits = map[string][]string{}
+	}
+
+	room := &Room{
+		Config: config,
+		Rolls:  rolls,
+		Stats:  stats,
+
+		Transactions: state.Transactions,
+
+		renderer: renderer,
+		store:    store,
+		clients:  make(map[*sseClient]bool),
+		events:   newEventLog(),
+	}
+
+	return room, nil
+}
+
+func (r *Room) Name() string { return r.Config.Name }
+
+// URL returns the absolute path of one of the room's routes, e.g. URL("roll") is "/r/{room}/roll".
+func (r *Room) URL(path string) string {
+	return "/r/" + r.Config.Name + "/" + path
+}
+
+// Roll rolls the dice for user, adds the roll to the history and tells every client about it. If addMomentum is set,
+// Momentum generated by the roll is added to the group pool. Any d20s bought for the roll add their cost to Threat, and
+// challenge dice tagged with an extended task do Work on it. A Determination die must already be paid for with
+// Stats.SpendDetermination; the point is given back if the roll can't be saved.
+func (r *Room) Roll(user *User, expr *DiceExpression, addMomentum bool) (Roll, error) {
+	return r.commitRoll(user, expr, expr.Roll(user), addMomentum)
+}
+
+// commitRoll adds a roll of expr to the history and settles its Momentum and Threat, like Roll.
+func (r *Room) commitRoll(user *User, expr *DiceExpression, roll Roll, addMomentum bool) (Roll, error) {
+	if expr.ExtendedTask != 0 {
+		roll.ExtendedTask = r.Stats.WorkFor(expr.ExtendedTask, roll)
+	}
+
+	if err := r.addRoll(&roll); err != nil {
+		if expr.Determination {
+			r.Stats.RefundDetermination(user.CharacterName)
+		}
+
+		return roll, err
+	}
+
+	r.NotifyRoll(roll)
+
+	statsChanged := expr.Determination
+
+	// the task only moves once the roll is in the history
+	if roll.ExtendedTask != nil && (roll.ExtendedTask.Work > 0 || roll.ExtendedTask.Breakthroughs > 0) {
+		r.Stats.ApplyWork(roll.ExtendedTask)
+
+		statsChanged = true
+	}
+
+	if statsChanged {
+		if err := r.saveStats(); err != nil {
+			return roll, err
+		}
+
+		r.NotifyClients(EventTypeStats)
+	}
+
+	if expr.BoughtDice > 0 {
+		if _, err := r.BuyDice(user, expr.BoughtDice, roll.ID); err != nil {
+			return roll, err
+		}
+	}
+
+	if roll.Task != nil && roll.Task.Momentum > 0 && addMomentum {
+		if _, err := r.AddRollMomentum(user, roll.Task.Momentum, roll.ID); err != nil {
+			return roll, err
+		}
+	}
+
+	return roll, nil
+}
+
+// addRoll assigns the roll its ID, saves it and adds it to the history.
+func (r *Room) addRoll(roll *Roll) error {
+	r.rollMutex.Lock()
+	defer r.rollMutex.Unlock()
+
+	return r.appendRoll(roll)
+}
+
+// History returns a copy of the roll history, newest first.
+func (r *Room) History() Rolls {
+	r.rollMutex.Lock()
+	defer r.rollMutex.Unlock()
+
+	return r.Rolls.Sort()
+}
+
+// FindRoll returns the roll with the given ID from the history.
+func (r *Room) FindRoll(id int) (Roll, bool) {
+	r.rollMutex.Lock()
+	defer r.rollMutex.Unlock()
+
+	if id < 1 || id > len(r.Rolls) {
+		return Roll{}, false
+	}
+
+	return r.Rolls[id-1], true
+}
+
+// appendRoll is addRoll for callers that already hold rollMutex.
+func (r *Room) appendRoll(roll *Roll) error {
+	roll.ID = len(r.Rolls) + 1
+
+	if err := r.store.AppendRoll(*roll); err != nil {
+		return fmt.Errorf("failed to save roll: %w", err)
+	}
+
+	r.Rolls = append(r.Rolls, *roll)
+
+	return nil
+}
+
+func (r *Room) saveStats() error {
+	r.statsMutex.Lock()
+	defer r.statsMutex.Unlock()
+
+	if err := r.store.SaveStats(r.Stats); err != nil {
+		return fmt.Errorf("failed to save stats: %w", err)
+	}
+
+	return nil
+}
+
+// roomFromPartyKey finds the room a player joins with partyKey.
+func (s *Server) roomFromPartyKey(partyKey string) *Room {
+	for _, room := range s.Rooms {
+		if room.Config.PartyKey == partyKey {
+			return room
+		}
+	}
+
+	return nil
+}
+
+// RoomMiddleware looks up the room named in the path and makes sure the user belongs to it.
+func (s *Server) RoomMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		room, ok := s.Rooms[req.PathValue("room")]
+		if !ok {
+			s.handleErr(writer, req, notFoundErr("There is no room called %q.", req.PathValue("room")))
+			return
+		}
+
+		user := UserFromContext(req)
+		if user == nil || user.Room != room.Name() {
+			http.Redirect(writer, req, "/", http.StatusSeeOther)
+			return
+		}
+
+		ctx := context.WithValue(req.Context(), roomKey, room)
+		req = req.WithContext(ctx)
+
+		next(writer, req)
+	}
+}
+
+func RoomFromContext(req *http.Request) *Room {
+	room, ok := req.Context().Value(roomKey).(*Room)
+	if !ok {
+		return nil
+	}
+
+	return room
+}
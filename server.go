@@ -1,14 +1,13 @@
 package main
 
 import (
-	"crypto/rand"
 	"crypto/tls"
 	"embed"
+	"expvar"
 	"fmt"
 	"io/fs"
 	"net/http"
 	"os"
-	"sync"
 	"time"
 
 	"github.com/gorilla/handlers"
@@ -24,6 +23,10 @@ type ServerOpts struct {
 	Host   string
 	Port   int
 	Config *Config
+	Stores map[string]Store // keyed by room name
+	Keys   *Keyring
+
+	Metrics bool // serve expvar metrics on /debug/vars
 }
 
 func (s *ServerOpts) OK() error {
@@ -32,6 +35,16 @@ func (s *ServerOpts) OK() error {
 		return fmt.Errorf("must supply Host")
 	case s.Port == 0:
 		return fmt.Errorf("must supply Port")
+	case s.Config == nil:
+		return fmt.Errorf("must supply Config")
+	case s.Keys == nil || len(s.Keys.Current) != keySize:
+		return fmt.Errorf("must supply a %d byte secret key", keySize)
+	}
+
+	for _, room := range s.Config.Rooms {
+		if s.Stores[room.Name] == nil {
+			return fmt.Errorf("must supply a Store for room %q", room.Name)
+		}
 	}
 
 	return nil
@@ -42,14 +55,9 @@ type Server struct {
 	Mux      *http.ServeMux
 	Server   *http.Server
 	Renderer *TemplateRenderer
-	Rolls    Rolls
-	Stats    *Stats
-
-	secretKey   []byte
-	rollMutex   sync.Mutex
-	statsMutex  sync.Mutex
-	clientMutex sync.RWMutex
-	clients     map[chan EventMessage]bool
+	Rooms    map[string]*Room
+
+	keys *Keyring
 }
 
 func NewServer(opts *ServerOpts) (*Server, error) {
@@ -57,15 +65,6 @@ func NewServer(opts *ServerOpts) (*Server, error) {
 		return nil, fmt.Errorf("invalid Server options: %w", err)
 	}
 
-	// generate a random secret key
-	secretKey := make([]byte, 32)
-	n, err := rand.Read(secretKey)
-	if n != 32 {
-		return nil, fmt.Errorf("invalid number of secret key bytes (%d)", n)
-	} else if err != nil {
-		return nil, fmt.Errorf("failed to generate secret key: %w", err)
-	}
-
 	mux := http.NewServeMux()
 
 	renderer, err := NewTemplateRenderer()
@@ -73,6 +72,17 @@ func NewServer(opts *ServerOpts) (*Server, error) {
 		return nil, fmt.Errorf("failed to set up template renderer: %w", err)
 	}
 
+	rooms := make(map[string]*Room, len(opts.Config.Rooms))
+
+	for _, roomConfig := range opts.Config.Rooms {
+		room, err := NewRoom(roomConfig, opts.Stores[roomConfig.Name], renderer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up room %q: %w", roomConfig.Name, err)
+		}
+
+		rooms[roomConfig.Name] = room
+	}
+
 	server := &Server{
 		Opts: opts,
 		Mux:  mux,
@@ -98,16 +108,9 @@ func NewServer(opts *ServerOpts) (*Server, error) {
 			},
 		},
 		Renderer: renderer,
-		Stats: &Stats{
-			Momentum: 0,
-			Threat:   0,
-		},
+		Rooms:    rooms,
 
-		secretKey:   secretKey,
-		rollMutex:   sync.Mutex{},
-		statsMutex:  sync.Mutex{},
-		clientMutex: sync.RWMutex{},
-		clients:     make(map[chan EventMessage]bool),
+		keys: opts.Keys,
 	}
 
 	if err := server.setupRoutes(); err != nil {
@@ -124,18 +127,69 @@ func (s *Server) setupRoutes() error {
 	}
 
 	s.Mux.HandleFunc("/", s.UserMiddleware(false, s.IndexHandler))
-	s.Mux.HandleFunc("GET /dice", s.UserMiddleware(true, s.DiceHandler))
-	s.Mux.HandleFunc("GET /sse", s.UserMiddleware(true, s.SSEHandler))
-	s.Mux.HandleFunc("GET /history", s.UserMiddleware(true, s.HistoryHandler))
-	s.Mux.HandleFunc("GET /stats", s.UserMiddleware(true, s.StatsHandler))
-	s.Mux.HandleFunc("POST /roll", s.UserMiddleware(true, s.RollHandler))
-	s.Mux.HandleFunc("POST /private-roll", s.UserMiddleware(true, s.GameMasterMiddleware(s.PrivateRollHandler)))
-	s.Mux.HandleFunc("POST /game-master", s.UserMiddleware(true, s.GameMasterMiddleware(s.GameMasterHandler)))
+	s.Mux.HandleFunc("/game-master/login", s.UserMiddleware(false, s.GameMasterLoginHandler))
+	s.Mux.HandleFunc("GET /dice", s.UserMiddleware(true, s.UserRoomHandler))
+	s.Mux.HandleFunc("GET /r/{room}/dice", s.roomRoute(s.DiceHandler))
+	s.Mux.HandleFunc("GET /r/{room}/sse", s.roomRoute(s.SSEHandler))
+	s.Mux.HandleFunc("GET /r/{room}/history", s.roomRoute(s.HistoryHandler))
+	s.Mux.HandleFunc("GET /r/{room}/stats", s.roomRoute(s.StatsHandler))
+	s.Mux.HandleFunc("POST /r/{room}/roll", s.roomRoute(s.RollHandler))
+	s.Mux.HandleFunc("POST /r/{room}/reroll", s.roomRoute(s.RerollHandler))
+	s.Mux.HandleFunc("GET /r/{room}/group-task", s.roomRoute(s.GroupTaskHandler))
+	s.Mux.HandleFunc("POST /r/{room}/group-task", s.roomRoute(s.OpenGroupTaskHandler))
+	s.Mux.HandleFunc("DELETE /r/{room}/group-task", s.roomRoute(s.CancelGroupTaskHandler))
+	s.Mux.HandleFunc("POST /r/{room}/group-task/assist", s.roomRoute(s.AssistGroupTaskHandler))
+	s.Mux.HandleFunc("POST /r/{room}/group-task/roll", s.roomRoute(s.RollGroupTaskHandler))
+	s.Mux.HandleFunc("GET /r/{room}/opposed", s.roomRoute(s.OpposedTaskHandler))
+	s.Mux.HandleFunc("POST /r/{room}/opposed", s.roomRoute(s.OpenOpposedTaskHandler))
+	s.Mux.HandleFunc("DELETE /r/{room}/opposed", s.roomRoute(s.CancelOpposedTaskHandler))
+	s.Mux.HandleFunc("POST /r/{room}/opposed/respond", s.roomRoute(s.RespondOpposedTaskHandler))
+	s.Mux.HandleFunc("POST /r/{room}/private-roll", s.roomRoute(s.GameMasterMiddleware(s.PrivateRollHandler)))
+	s.Mux.HandleFunc("POST /r/{room}/game-master", s.roomRoute(s.GameMasterMiddleware(s.GameMasterHandler)))
+	s.Mux.HandleFunc("GET /r/{room}/transactions", s.roomRoute(s.TransactionsHandler))
+	s.Mux.HandleFunc("POST /r/{room}/momentum", s.roomRoute(s.SpendMomentumHandler))
+	s.Mux.HandleFunc("POST /r/{room}/transactions/{id}/revert", s.roomRoute(s.GameMasterMiddleware(s.RevertTransactionHandler)))
+	s.Mux.HandleFunc("POST /r/{room}/transactions/undo", s.roomRoute(s.GameMasterMiddleware(s.UndoHandler)))
+	s.Mux.HandleFunc("POST /r/{room}/transactions/redo", s.roomRoute(s.GameMasterMiddleware(s.RedoHandler)))
+	s.Mux.HandleFunc("GET /r/{room}/extended-tasks", s.roomRoute(s.GameMasterMiddleware(s.ExtendedTasksHandler)))
+	s.Mux.HandleFunc("GET /r/{room}/extended-tasks/select", s.roomRoute(s.ExtendedTaskSelectHandler))
+	s.Mux.HandleFunc("POST /r/{room}/extended-tasks", s.roomRoute(s.GameMasterMiddleware(s.AddExtendedTaskHandler)))
+	s.Mux.HandleFunc("DELETE /r/{room}/extended-tasks/{id}", s.roomRoute(s.GameMasterMiddleware(s.RemoveExtendedTaskHandler)))
+	s.Mux.HandleFunc("GET /r/{room}/character-sheet", s.roomRoute(s.CharacterSheetHandler))
+	s.Mux.HandleFunc("POST /r/{room}/character-sheet", s.roomRoute(s.SaveCharacterSheetHandler))
+	s.Mux.HandleFunc("GET /r/{room}/character-sheet/picker", s.roomRoute(s.CharacterSheetPickerHandler))
+	s.Mux.HandleFunc("GET /r/{room}/character-sheet/export", s.roomRoute(s.ExportCharacterSheetHandler))
+	s.Mux.HandleFunc("POST /r/{room}/character-sheet/import", s.roomRoute(s.ImportCharacterSheetHandler))
+	s.Mux.HandleFunc("GET /r/{room}/conflict", s.roomRoute(s.ConflictHandler))
+	s.Mux.HandleFunc("POST /r/{room}/conflict", s.roomRoute(s.GameMasterMiddleware(s.StartConflictHandler)))
+	s.Mux.HandleFunc("DELETE /r/{room}/conflict", s.roomRoute(s.GameMasterMiddleware(s.EndConflictHandler)))
+	s.Mux.HandleFunc("POST /r/{room}/conflict/turn", s.roomRoute(s.TakeTurnHandler))
+	s.Mux.HandleFunc("POST /r/{room}/conflict/end-turn", s.roomRoute(s.EndTurnHandler))
+	s.Mux.HandleFunc("DELETE /r/{room}/conflict/combatants", s.roomRoute(s.GameMasterMiddleware(s.RemoveCombatantHandler)))
+	s.Mux.HandleFunc("GET /r/{room}/npcs", s.roomRoute(s.GameMasterMiddleware(s.NPCRosterHandler)))
+	s.Mux.HandleFunc("POST /r/{room}/npcs", s.roomRoute(s.GameMasterMiddleware(s.AddNPCHandler)))
+	s.Mux.HandleFunc("DELETE /r/{room}/npcs/{id}", s.roomRoute(s.GameMasterMiddleware(s.RemoveNPCHandler)))
+	s.Mux.HandleFunc("POST /r/{room}/npcs/{id}/stress", s.roomRoute(s.GameMasterMiddleware(s.NPCStressHandler)))
+	s.Mux.HandleFunc("POST /r/{room}/npcs/{id}/roll", s.roomRoute(s.GameMasterMiddleware(s.NPCRollHandler)))
+	s.Mux.HandleFunc("GET /r/{room}/character-traits", s.roomRoute(s.GameMasterMiddleware(s.CharacterTraitsHandler)))
+	s.Mux.HandleFunc("POST /r/{room}/character-traits", s.roomRoute(s.GameMasterMiddleware(s.AddCharacterTraitHandler)))
+	s.Mux.HandleFunc("DELETE /r/{room}/character-traits", s.roomRoute(s.GameMasterMiddleware(s.RemoveCharacterTraitHandler)))
+	s.setupAPIRoutes()
+
+	if s.Opts.Metrics {
+		s.Mux.Handle("GET /debug/vars", expvar.Handler())
+	}
+
 	s.Mux.Handle("GET /static/", http.StripPrefix("/static/", http.FileServer(http.FS(staticFS))))
 
 	return nil
 }
 
+// roomRoute requires a logged-in user who belongs to the room in the path.
+func (s *Server) roomRoute(next http.HandlerFunc) http.HandlerFunc {
+	return s.UserMiddleware(true, s.RoomMiddleware(next))
+}
+
 func (s *Server) Start() error {
 	fmt.Printf("Listening on %s:%d...\n", s.Opts.Host, s.Opts.Port)
 
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newTestServer starts a server with one room, "default", kept in memory.
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+
+	config := &Config{GameMasterName: "GM", GameMasterPasswordHash: string(hash), PartyKey: "KEY"}
+	if err := config.OK(); err != nil {
+		t.Fatalf("invalid test config: %v", err)
+	}
+
+	key, err := generateKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	server, err := NewServer(&ServerOpts{
+		Host:   "localhost",
+		Port:   8080,
+		Config: config,
+		Stores: map[string]Store{defaultRoomName: &MemoryStore{}},
+		Keys:   &Keyring{Current: key},
+	})
+	if err != nil {
+		t.Fatalf("failed to set up server: %v", err)
+	}
+
+	return server
+}
+
+// testPlayer returns a player in the default room playing character.
+func testPlayer(name, character string) *User {
+	return &User{Name: name, CharacterName: character, Room: defaultRoomName}
+}
+
+// testGameMaster returns the default room's Game Master, with a valid claim.
+func testGameMaster(server *Server) *User {
+	user := &User{Name: "GM", Room: defaultRoomName, IsGameMaster: true}
+	user.GameMasterClaim = server.Rooms[defaultRoomName].gameMasterClaim(user)
+
+	return user
+}
+
+// serve sends a request as user, or with no session if user is nil. A body that isn't a string is sent as JSON.
+func serve(t *testing.T, server *Server, user *User, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var (
+		reader      io.Reader
+		contentType string
+	)
+
+	switch body := body.(type) {
+	case nil:
+	case string:
+		reader = strings.NewReader(body)
+		contentType = "application/x-www-form-urlencoded"
+	default:
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("failed to encode body: %v", err)
+		}
+
+		reader = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	if user != nil {
+		cookie, err := user.DataCookie(server.keys.Current)
+		if err != nil {
+			t.Fatalf("failed to make cookie: %v", err)
+		}
+
+		req.AddCookie(cookie)
+	}
+
+	recorder := httptest.NewRecorder()
+	server.Mux.ServeHTTP(recorder, req)
+
+	return recorder
+}
+
+// decodeJSON decodes a response body into target.
+func decodeJSON(t *testing.T, recorder *httptest.ResponseRecorder, target any) {
+	t.Helper()
+
+	if err := json.NewDecoder(recorder.Body).Decode(target); err != nil {
+		t.Fatalf("failed to decode %q: %v", recorder.Body.String(), err)
+	}
+}
+
+// wantStatus fails the test if the response doesn't have the status code.
+func wantStatus(t *testing.T, recorder *httptest.ResponseRecorder, status int) {
+	t.Helper()
+
+	if recorder.Code != status {
+		t.Fatalf("got status %d (%s), want %d", recorder.Code, recorder.Body.String(), status)
+	}
+}
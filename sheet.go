@@ -0,0 +1,343 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxAttribute     = 12
+	maxDiscipline    = 5
+	maxDetermination = 3
+)
+
+var (
+	Attributes  = []string{"Control", "Daring", "Fitness", "Insight", "Presence", "Reason"}
+	Disciplines = []string{"Command", "Conn", "Engineering", "Security", "Science", "Medicine"}
+)
+
+// CharacterSheet is everything about a character that their rolls need. It belongs to the player who saved it first,
+// or to nobody yet if the Game Master made it for them.
+type CharacterSheet struct {
+	Character     string         `json:"character"`
+	Owner         string         `json:"owner"` // the player's name
+	Attributes    map[string]int `json:"attributes"`
+	Disciplines   map[string]int `json:"disciplines"`
+	Focuses       []string       `json:"focuses"`
+	Values        []string       `json:"values"`
+	Talents       []string       `json:"talents"`
+	Stress        int            `json:"stress"`
+	Determination int            `json:"determination"`
+	Injuries      []string       `json:"injuries,omitempty"`
+}
+
+// newCharacterSheet starts a sheet with the lowest attributes and disciplines a player character has.
+func newCharacterSheet(character string) *CharacterSheet {
+	sheet := &CharacterSheet{
+		Character:   character,
+		Attributes:  map[string]int{},
+		Disciplines: map[string]int{},
+	}
+
+	for _, attribute := range Attributes {
+		sheet.Attributes[attribute] = 7
+	}
+
+	for _, discipline := range Disciplines {
+		sheet.Disciplines[discipline] = 1
+	}
+
+	return sheet
+}
+
+func (c *CharacterSheet) clone() *CharacterSheet {
+	sheet := *c
+	sheet.Attributes = maps.Clone(c.Attributes)
+	sheet.Disciplines = maps.Clone(c.Disciplines)
+	sheet.Focuses = slices.Clone(c.Focuses)
+	sheet.Values = slices.Clone(c.Values)
+	sheet.Talents = slices.Clone(c.Talents)
+	sheet.Injuries = slices.Clone(c.Injuries)
+
+	return &sheet
+}
+
+// Task returns the target number and crit range for a roll with attribute and discipline. With a focus, every die at
+// or below the discipline is a crit.
+func (c *CharacterSheet) Task(attribute, discipline, focus string) (int, int, error) {
+	attributeValue, ok := c.Attributes[attribute]
+	if !ok {
+		return 0, 0, validationErr("%s has no attribute %q.", c.Character, attribute)
+	}
+
+	disciplineValue, ok := c.Disciplines[discipline]
+	if !ok {
+		return 0, 0, validationErr("%s has no discipline %q.", c.Character, discipline)
+	}
+
+	critOn := 1
+
+	if focus != "" {
+		if !slices.Contains(c.Focuses, focus) {
+			return 0, 0, validationErr("%s has no focus %q.", c.Character, focus)
+		}
+
+		critOn = max(disciplineValue, 1)
+	}
+
+	return attributeValue + disciplineValue, critOn, nil
+}
+
+// CharacterSheet returns a copy of character's sheet, or nil if they don't have one.
+func (s *Stats) CharacterSheet(character string) *CharacterSheet {
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+
+	sheet, ok := s.CharacterSheets[character]
+	if !ok {
+		return nil
+	}
+
+	return sheet.clone()
+}
+
+func (s *Stats) SetCharacterSheet(sheet *CharacterSheet) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	if s.CharacterSheets == nil {
+		s.CharacterSheets = map[string]*CharacterSheet{}
+	}
+
+	s.CharacterSheets[sheet.Character] = sheet.clone()
+}
+
+// characterDice reads dice from the form like diceFromForm. If an attribute and discipline are picked, the target
+// number and crit range come from user's character sheet instead.
+func (r *Room) characterDice(user *User, form url.Values) (*DiceExpression, error) {
+	expr, err := diceFromForm(form)
+	if err != nil {
+		return nil, err
+	}
+
+	attribute, discipline := form.Get("attribute"), form.Get("discipline")
+	if attribute == "" && discipline == "" {
+		return expr, nil
+	}
+
+	sheet := r.Stats.CharacterSheet(user.CharacterName)
+	if sheet == nil {
+		return nil, validationErr("Fill in your character sheet before picking an attribute and discipline.")
+	}
+
+	if expr.Challenge {
+		return nil, validationErr("Challenge dice don't use an attribute or discipline.")
+	}
+
+	target, critOn, err := sheet.Task(attribute, discipline, form.Get("focus"))
+	if err != nil {
+		return nil, err
+	}
+
+	expr.Target = target
+	expr.Focus = critOn
+
+	return expr, nil
+}
+
+// editableCharacterSheet returns a copy of character's sheet for user to change, or a new one if there isn't one yet.
+// A sheet belongs to the first player to save their own character's sheet; one the Game Master starts for someone
+// else's character has no owner until that character's player claims it.
+func (r *Room) editableCharacterSheet(user *User, character string) (*CharacterSheet, error) {
+	if character == "" {
+		return nil, validationErr("Name the character.")
+	}
+
+	sheet := r.Stats.CharacterSheet(character)
+	if sheet == nil {
+		sheet = newCharacterSheet(character)
+	}
+
+	switch {
+	case sheet.Owner == "" && character == user.CharacterName:
+		sheet.Owner = user.Name
+	case sheet.Owner != "" && sheet.Owner != user.Name && !user.IsGameMaster:
+		return nil, forbiddenErr("%s's sheet belongs to %s.", character, sheet.Owner)
+	}
+
+	return sheet, nil
+}
+
+// sheetCharacter is the character whose sheet is being viewed or saved: the player's own, or any for the Game Master.
+func sheetCharacter(user *User, form url.Values) string {
+	if character := strings.TrimSpace(form.Get("character")); user.IsGameMaster && character != "" {
+		return character
+	}
+
+	return user.CharacterName
+}
+
+func (s *Server) CharacterSheetHandler(writer http.ResponseWriter, req *http.Request) {
+	user := UserFromContext(req)
+
+	s.renderCharacterSheet(writer, req, sheetCharacter(user, req.URL.Query()))
+}
+
+func (s *Server) SaveCharacterSheetHandler(writer http.ResponseWriter, req *http.Request) {
+	user := UserFromContext(req)
+	room := RoomFromContext(req)
+
+	if err := req.ParseForm(); err != nil {
+		s.handleErr(writer, req, validationErr("Failed to parse form: %v", err))
+		return
+	}
+
+	character := sheetCharacter(user, req.Form)
+
+	sheet, err := room.editableCharacterSheet(user, character)
+	if err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	if err := characterSheetFromForm(sheet, req.Form); err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	room.Stats.SetCharacterSheet(sheet)
+
+	if err := room.saveStats(); err != nil {
+		s.handleErr(writer, req, internalErr(err, "failed to save stats"))
+		return
+	}
+
+	room.NotifyClients(EventTypeStats)
+
+	// the roll form's attribute and discipline pickers reload when they hear this
+	writer.Header().Set("HX-Trigger", "characterSheetSaved")
+
+	s.renderCharacterSheet(writer, req, character)
+}
+
+// CharacterSheetPickerHandler renders the attribute, discipline and focus pickers for the roll form.
+func (s *Server) CharacterSheetPickerHandler(writer http.ResponseWriter, req *http.Request) {
+	user := UserFromContext(req)
+	room := RoomFromContext(req)
+
+	data := struct {
+		Room  *Room
+		Sheet *CharacterSheet
+	}{
+		Room:  room,
+		Sheet: room.Stats.CharacterSheet(user.CharacterName),
+	}
+
+	if err := s.Renderer.ExecuteSingle(writer, "character_sheet_picker", data); err != nil {
+		s.handleErr(writer, req, internalErr(err, "failed to execute character sheet picker template"))
+		return
+	}
+}
+
+func characterSheetFromForm(sheet *CharacterSheet, form url.Values) error {
+	attributes, disciplines, err := scoresFromForm(form)
+	if err != nil {
+		return err
+	}
+
+	sheet.Attributes = attributes
+	sheet.Disciplines = disciplines
+
+	stress, err := strconv.Atoi(form.Get("stress"))
+	if err != nil || stress < 0 || stress > sheet.MaxStress() {
+		return validationErr("Stress must be between 0 and %d (Fitness + Security).", sheet.MaxStress())
+	}
+
+	determination, err := strconv.Atoi(form.Get("determination"))
+	if err != nil || determination < 0 || determination > maxDetermination {
+		return validationErr("Determination must be between 0 and %d.", maxDetermination)
+	}
+
+	sheet.Stress = stress
+	sheet.Determination = determination
+	sheet.Focuses = splitList(form.Get("focuses"))
+	sheet.Values = splitList(form.Get("values"))
+	sheet.Talents = splitList(form.Get("talents"))
+	sheet.Injuries = splitList(form.Get("injuries"))
+
+	return nil
+}
+
+// scoresFromForm reads every attribute and discipline from "attribute-..." and "discipline-..." fields.
+func scoresFromForm(form url.Values) (map[string]int, map[string]int, error) {
+	attributes := map[string]int{}
+	disciplines := map[string]int{}
+
+	for _, attribute := range Attributes {
+		value, err := strconv.Atoi(form.Get("attribute-" + attribute))
+		if err != nil || value < 0 || value > maxAttribute {
+			return nil, nil, validationErr("%s must be between 0 and %d.", attribute, maxAttribute)
+		}
+
+		attributes[attribute] = value
+	}
+
+	for _, discipline := range Disciplines {
+		value, err := strconv.Atoi(form.Get("discipline-" + discipline))
+		if err != nil || value < 0 || value > maxDiscipline {
+			return nil, nil, validationErr("%s must be between 0 and %d.", discipline, maxDiscipline)
+		}
+
+		disciplines[discipline] = value
+	}
+
+	return attributes, disciplines, nil
+}
+
+// splitList splits a comma-separated list, dropping empty items.
+func splitList(input string) []string {
+	var items []string
+
+	for _, item := range strings.Split(input, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+func (s *Server) renderCharacterSheet(writer http.ResponseWriter, req *http.Request, character string) {
+	user := UserFromContext(req)
+	room := RoomFromContext(req)
+
+	sheet := room.Stats.CharacterSheet(character)
+	if sheet == nil {
+		sheet = newCharacterSheet(character)
+	}
+
+	data := struct {
+		User        *User
+		Room        *Room
+		Sheet       *CharacterSheet
+		Attributes  []string
+		Disciplines []string
+		Editable    bool
+	}{
+		User:        user,
+		Room:        room,
+		Sheet:       sheet,
+		Attributes:  Attributes,
+		Disciplines: Disciplines,
+		Editable:    user.IsGameMaster || sheet.Owner == "" || sheet.Owner == user.Name,
+	}
+
+	if err := s.Renderer.ExecuteSingle(writer, "character_sheet", data); err != nil {
+		s.handleErr(writer, req, internalErr(err, "failed to execute character sheet template"))
+		return
+	}
+}
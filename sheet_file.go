@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CharacterFileVersion is the version of the character file format this server writes. Files from older versions are
+// still read; newer ones are refused.
+const CharacterFileVersion = 1
+
+const (
+	CharacterFormatJSON = "json"
+	CharacterFormatYAML = "yaml"
+)
+
+// maxCharacterFileSize is the largest character file that can be imported over HTTP.
+const maxCharacterFileSize = 64 << 10
+
+// CharacterFile is the portable form of a character sheet, for moving characters between rooms and servers. Stress,
+// Determination and Injuries change from session to session, so they stay with the room.
+type CharacterFile struct {
+	Version     int            `json:"version" yaml:"version"`
+	Character   string         `json:"character" yaml:"character"`
+	Attributes  map[string]int `json:"attributes" yaml:"attributes"`
+	Disciplines map[string]int `json:"disciplines" yaml:"disciplines"`
+	Focuses     []string       `json:"focuses,omitempty" yaml:"focuses,omitempty"`
+	Values      []string       `json:"values,omitempty" yaml:"values,omitempty"`
+	Talents     []string       `json:"talents,omitempty" yaml:"talents,omitempty"`
+}
+
+var characterFileFields = []string{"version", "character", "attributes", "disciplines", "focuses", "values", "talents"}
+
+func NewCharacterFile(sheet *CharacterSheet) *CharacterFile {
+	return &CharacterFile{
+		Version:     CharacterFileVersion,
+		Character:   sheet.Character,
+		Attributes:  sheet.Attributes,
+		Disciplines: sheet.Disciplines,
+		Focuses:     sheet.Focuses,
+		Values:      sheet.Values,
+		Talents:     sheet.Talents,
+	}
+}
+
+// ParseCharacterFile reads a character file in either format, since JSON is also YAML. Anything wrong with it comes
+// back as a validation error listing each offending field, e.g. "attributes.Daring" or "focuses[2]".
+func ParseCharacterFile(data []byte) (*CharacterFile, error) {
+	var raw map[string]any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, validationErr("Couldn't read the character file: %v", err)
+	}
+
+	if raw == nil {
+		return nil, validationErr("The character file is empty.")
+	}
+
+	var fields []FieldError
+
+	for key := range raw {
+		if !slices.Contains(characterFileFields, key) {
+			fields = append(fields, FieldError{Field: key, Message: "Unknown field."})
+		}
+	}
+
+	// going through JSON gives type errors the path of the field they're in
+	normalized, err := json.Marshal(raw)
+	if err != nil {
+		return nil, validationErr("Couldn't read the character file: %v", err)
+	}
+
+	file := &CharacterFile{}
+
+	if err := json.Unmarshal(normalized, file); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(err, &typeErr) {
+			return nil, validationErr("Couldn't read the character file: %v", err)
+		}
+
+		message := "Must be " + describeType(typeErr.Type.Kind().String()) + "."
+		fields = append(fields, FieldError{Field: typeErr.Field, Message: message})
+	} else {
+		fields = append(fields, file.check()...)
+	}
+
+	if len(fields) > 0 {
+		slices.SortStableFunc(fields, func(a, b FieldError) int { return strings.Compare(a.Field, b.Field) })
+
+		fileErr := validationErr("The character file isn't valid.")
+		fileErr.Fields = fields
+
+		return nil, fileErr
+	}
+
+	return file, nil
+}
+
+// describeType names a Go kind the way a character file's author would think of it.
+func describeType(kind string) string {
+	switch kind {
+	case "int":
+		return "a whole number"
+	case "string":
+		return "text"
+	case "map":
+		return "a list of names and numbers"
+	case "slice":
+		return "a list"
+	}
+
+	return "a " + kind
+}
+
+// check reports every field of the file that doesn't make a valid character sheet.
+func (c *CharacterFile) check() []FieldError {
+	var fields []FieldError
+
+	invalid := func(field, format string, args ...any) {
+		fields = append(fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch {
+	case c.Version == 0:
+		invalid("version", "Missing; this server writes version %d.", CharacterFileVersion)
+	case c.Version < 0 || c.Version > CharacterFileVersion:
+		invalid("version", "Version %d isn't supported; this server reads up to version %d.", c.Version,
+			CharacterFileVersion)
+	}
+
+	if strings.TrimSpace(c.Character) == "" {
+		invalid("character", "Name the character.")
+	}
+
+	scores := []struct {
+		field string
+		names []string
+		max   int
+		got   map[string]int
+	}{
+		{"attributes", Attributes, maxAttribute, c.Attributes},
+		{"disciplines", Disciplines, maxDiscipline, c.Disciplines},
+	}
+
+	for _, score := range scores {
+		for _, name := range score.names {
+			value, ok := score.got[name]
+
+			switch {
+			case !ok:
+				invalid(score.field+"."+name, "Missing.")
+			case value < 0 || value > score.max:
+				invalid(score.field+"."+name, "Must be between 0 and %d.", score.max)
+			}
+		}
+
+		for name := range score.got {
+			if !slices.Contains(score.names, name) {
+				invalid(score.field+"."+name, "Unknown; use %s.", strings.Join(score.names, ", "))
+			}
+		}
+	}
+
+	lists := []struct {
+		field string
+		items []string
+	}{
+		{"focuses", c.Focuses},
+		{"values", c.Values},
+		{"talents", c.Talents},
+	}
+
+	for _, list := range lists {
+		for i, item := range list.items {
+			field := fmt.Sprintf("%s[%d]", list.field, i)
+
+			// the sheet edits these as comma-separated lists
+			switch {
+			case strings.TrimSpace(item) == "":
+				invalid(field, "Must not be empty.")
+			case strings.Contains(item, ","):
+				invalid(field, "Must not contain commas.")
+			}
+		}
+	}
+
+	return fields
+}
+
+// Sheet makes a sheet from the file, with trimmed names and no owner.
+func (c *CharacterFile) Sheet() *CharacterSheet {
+	trim := func(items []string) []string {
+		var trimmed []string
+		for _, item := range items {
+			trimmed = append(trimmed, strings.TrimSpace(item))
+		}
+
+		return trimmed
+	}
+
+	sheet := &CharacterSheet{
+		Character:   strings.TrimSpace(c.Character),
+		Attributes:  c.Attributes,
+		Disciplines: c.Disciplines,
+		Focuses:     trim(c.Focuses),
+		Values:      trim(c.Values),
+		Talents:     trim(c.Talents),
+	}
+
+	return sheet.clone()
+}
+
+// Apply copies the file onto sheet, leaving its character, owner, Stress, Determination and Injuries alone. Stress is
+// capped at the new maximum.
+func (c *CharacterFile) Apply(sheet *CharacterSheet) {
+	imported := c.Sheet()
+
+	sheet.Attributes = imported.Attributes
+	sheet.Disciplines = imported.Disciplines
+	sheet.Focuses = imported.Focuses
+	sheet.Values = imported.Values
+	sheet.Talents = imported.Talents
+	sheet.Stress = min(sheet.Stress, sheet.MaxStress())
+}
+
+// Encode writes the file in format, which is CharacterFormatJSON or CharacterFormatYAML.
+func (c *CharacterFile) Encode(writer io.Writer, format string) error {
+	switch format {
+	case CharacterFormatJSON:
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(c)
+	case CharacterFormatYAML:
+		encoder := yaml.NewEncoder(writer)
+		encoder.SetIndent(2)
+
+		if err := encoder.Encode(c); err != nil {
+			return err
+		}
+
+		return encoder.Close()
+	}
+
+	return fmt.Errorf("unknown character file format %q", format)
+}
+
+// characterFormat checks a format chosen by the user, defaulting to JSON.
+func characterFormat(format string) (string, error) {
+	switch format {
+	case "", CharacterFormatJSON:
+		return CharacterFormatJSON, nil
+	case CharacterFormatYAML, "yml":
+		return CharacterFormatYAML, nil
+	}
+
+	return "", validationErr("Export as %s or %s.", CharacterFormatJSON, CharacterFormatYAML)
+}
+
+func (s *Server) ExportCharacterSheetHandler(writer http.ResponseWriter, req *http.Request) {
+	user := UserFromContext(req)
+	room := RoomFromContext(req)
+	character := sheetCharacter(user, req.URL.Query())
+
+	format, err := characterFormat(req.URL.Query().Get("format"))
+	if err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	sheet := room.Stats.CharacterSheet(character)
+	if sheet == nil {
+		s.handleErr(writer, req, notFoundErr("%s doesn't have a character sheet yet.", character))
+		return
+	}
+
+	// encode first, so a failure still gets an error page instead of half a download
+	var buf bytes.Buffer
+
+	if err := NewCharacterFile(sheet).Encode(&buf, format); err != nil {
+		s.handleErr(writer, req, internalErr(err, "failed to encode character file"))
+		return
+	}
+
+	contentType := "application/json"
+	if format == CharacterFormatYAML {
+		contentType = "application/yaml"
+	}
+
+	writer.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	writer.Header().Set("Content-Disposition",
+		mime.FormatMediaType("attachment", map[string]string{"filename": character + "." + format}))
+
+	if _, err := buf.WriteTo(writer); err != nil {
+		log.Printf("Error writing character file: %v", err)
+	}
+}
+
+// ImportCharacterSheetHandler replaces the attributes, disciplines, focuses, values and talents on a character sheet
+// with those from an uploaded file, or from the request body if it isn't a form. Players import into their own
+// character whatever the file calls it; the Game Master imports into the character on the form or in the file.
+func (s *Server) ImportCharacterSheetHandler(writer http.ResponseWriter, req *http.Request) {
+	user := UserFromContext(req)
+	room := RoomFromContext(req)
+
+	data, err := characterFileFromRequest(writer, req)
+	if err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	file, err := ParseCharacterFile(data)
+	if err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	character := user.CharacterName
+	if user.IsGameMaster {
+		character = strings.TrimSpace(file.Character)
+
+		if override := strings.TrimSpace(req.FormValue("character")); override != "" {
+			character = override
+		}
+	}
+
+	sheet, err := room.editableCharacterSheet(user, character)
+	if err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	file.Apply(sheet)
+	room.Stats.SetCharacterSheet(sheet)
+
+	if err := room.saveStats(); err != nil {
+		s.handleErr(writer, req, internalErr(err, "failed to save stats"))
+		return
+	}
+
+	room.NotifyClients(EventTypeStats)
+
+	writer.Header().Set("HX-Trigger", "characterSheetSaved")
+
+	s.renderCharacterSheet(writer, req, character)
+}
+
+// characterFileFromRequest reads the "file" upload of a multipart form, or else the whole body.
+func characterFileFromRequest(writer http.ResponseWriter, req *http.Request) ([]byte, error) {
+	req.Body = http.MaxBytesReader(writer, req.Body, maxCharacterFileSize)
+
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+
+	if mediaType != "multipart/form-data" {
+		data, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, validationErr("Character files can be up to %d KiB.", maxCharacterFileSize>>10)
+		}
+
+		return data, nil
+	}
+
+	if err := req.ParseMultipartForm(maxCharacterFileSize); err != nil {
+		return nil, validationErr("Character files can be up to %d KiB.", maxCharacterFileSize>>10)
+	}
+
+	upload, _, err := req.FormFile("file")
+	if err != nil {
+		return nil, validationErr("Choose a character file to import.")
+	}
+	defer upload.Close()
+
+	data, err := io.ReadAll(upload)
+	if err != nil {
+		return nil, validationErr("Failed to read the character file: %v", err)
+	}
+
+	return data, nil
+}
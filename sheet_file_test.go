@@ -0,0 +1,115 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCharacterFile(t *testing.T) {
+	const scores = `
+attributes: {Control: 9, Daring: 10, Fitness: 8, Insight: 7, Presence: 11, Reason: 9}
+disciplines: {Command: 5, Conn: 3, Engineering: 2, Security: 3, Science: 2, Medicine: 1}
+`
+
+	tests := []struct {
+		name       string
+		data       string
+		wantErr    string
+		wantFields []string
+	}{
+		{
+			name: "YAML",
+			data: "version: 1\ncharacter: Kirk\nfocuses: [Diplomacy, Starship Combat]\n" + scores,
+		},
+		{
+			name: "JSON",
+			data: `{"version": 1, "character": "Kirk", "attributes": {"Control": 9, "Daring": 10, "Fitness": 8, ` +
+				`"Insight": 7, "Presence": 11, "Reason": 9}, "disciplines": {"Command": 5, "Conn": 3, ` +
+				`"Engineering": 2, "Security": 3, "Science": 2, "Medicine": 1}, "focuses": ["Diplomacy", ` +
+				`"Starship Combat"]}`,
+		},
+		{
+			name:    "empty",
+			data:    "",
+			wantErr: "The character file is empty.",
+		},
+		{
+			name:    "not YAML",
+			data:    "version: [1",
+			wantErr: "Couldn't read the character file: yaml: line 1: did not find expected ',' or ']'",
+		},
+		{
+			name:       "missing version and character",
+			data:       scores,
+			wantErr:    "The character file isn't valid.",
+			wantFields: []string{"character", "version"},
+		},
+		{
+			name:       "newer version",
+			data:       "version: 2\ncharacter: Kirk\n" + scores,
+			wantErr:    "The character file isn't valid.",
+			wantFields: []string{"version"},
+		},
+		{
+			name:       "unknown field",
+			data:       "version: 1\ncharacter: Kirk\nrank: Captain\n" + scores,
+			wantErr:    "The character file isn't valid.",
+			wantFields: []string{"rank"},
+		},
+		{
+			name:       "list of the wrong type",
+			data:       "version: 1\ncharacter: Kirk\n" + scores + "focuses: Diplomacy\n",
+			wantErr:    "The character file isn't valid.",
+			wantFields: []string{"focuses"},
+		},
+		{
+			name: "score of the wrong type",
+			data: "version: 1\ncharacter: Kirk\n" +
+				"attributes: {Control: 9, Daring: ten, Fitness: 8, Insight: 7, Presence: 11, Reason: 9}\n" +
+				"disciplines: {Command: 5, Conn: 3, Engineering: 2, Security: 3, Science: 2, Medicine: 1}\n",
+			wantErr:    "The character file isn't valid.",
+			wantFields: []string{"attributes.Daring"},
+		},
+		{
+			name: "missing, unknown and out of range scores",
+			data: "version: 1\ncharacter: Kirk\n" +
+				"attributes: {Control: 9, Daring: 13, Fitness: 8, Insight: 7, Presence: 11, Luck: 9}\n" +
+				"disciplines: {Command: 5, Conn: 3, Engineering: 2, Security: 3, Science: 2, Medicine: -1}\n",
+			wantErr: "The character file isn't valid.",
+			wantFields: []string{
+				"attributes.Daring", "attributes.Luck", "attributes.Reason", "disciplines.Medicine",
+			},
+		},
+		{
+			name:       "bad list items",
+			data:       "version: 1\ncharacter: Kirk\nfocuses: [Diplomacy, ' ', 'Tactics, Strategy']\n" + scores,
+			wantErr:    "The character file isn't valid.",
+			wantFields: []string{"focuses[1]", "focuses[2]"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file, err := ParseCharacterFile([]byte(test.data))
+			if mismatch := errMismatch(err, test.wantErr); mismatch != "" {
+				t.Fatal(mismatch)
+			}
+
+			if test.wantErr != "" {
+				if fields := errFields(err); !reflect.DeepEqual(fields, test.wantFields) {
+					t.Errorf("got fields %v, want %v", fields, test.wantFields)
+				}
+
+				return
+			}
+
+			if file.Character != "Kirk" || file.Attributes["Presence"] != 11 || file.Disciplines["Command"] != 5 {
+				t.Errorf("got %+v", file)
+			}
+
+			if want := []string{"Diplomacy", "Starship Combat"}; !reflect.DeepEqual(file.Focuses, want) {
+				t.Errorf("got focuses %v, want %v", file.Focuses, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCharacterSheetOwner(t *testing.T) {
+	server := newTestServer(t)
+	room := server.Rooms[defaultRoomName]
+
+	file := func(character string) *CharacterFile {
+		sheet := newCharacterSheet(character)
+		for _, name := range Attributes {
+			sheet.Attributes[name] = 8
+		}
+
+		for _, name := range Disciplines {
+			sheet.Disciplines[name] = 2
+		}
+
+		return NewCharacterFile(sheet)
+	}
+
+	owner := func(character string) string {
+		t.Helper()
+
+		sheet := room.Stats.CharacterSheet(character)
+		if sheet == nil {
+			t.Fatalf("no sheet for %s", character)
+		}
+
+		return sheet.Owner
+	}
+
+	// the Game Master imports Kirk before his player has saved anything
+	recorder := serve(t, server, testGameMaster(server), "POST", "/r/default/character-sheet/import", file("Kirk"))
+	wantStatus(t, recorder, http.StatusOK)
+
+	if got := owner("Kirk"); got != "" {
+		t.Fatalf("got owner %q for a sheet the Game Master made, want none", got)
+	}
+
+	// Kirk's player claims it by importing over it
+	recorder = serve(t, server, testPlayer("alice", "Kirk"), "POST", "/r/default/character-sheet/import", file("Kirk"))
+	wantStatus(t, recorder, http.StatusOK)
+
+	if got := owner("Kirk"); got != "alice" {
+		t.Fatalf("got owner %q, want alice", got)
+	}
+
+	// after that it's theirs
+	recorder = serve(t, server, testPlayer("bob", "Kirk"), "POST", "/r/default/character-sheet/import", file("Kirk"))
+	wantStatus(t, recorder, http.StatusForbidden)
+
+	// the Game Master can still edit it without taking it over
+	recorder = serve(t, server, testGameMaster(server), "POST", "/r/default/character-sheet/import", file("Kirk"))
+	wantStatus(t, recorder, http.StatusOK)
+
+	if got := owner("Kirk"); got != "alice" {
+		t.Fatalf("got owner %q after the Game Master's import, want alice", got)
+	}
+
+	// a player's first sheet is theirs straight away
+	recorder = serve(t, server, testPlayer("carol", "Spock"), "POST", "/r/default/character-sheet/import", file("Spock"))
+	wantStatus(t, recorder, http.StatusOK)
+
+	if got := owner("Spock"); got != "carol" {
+		t.Fatalf("got owner %q, want carol", got)
+	}
+}
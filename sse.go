@@ -2,16 +2,20 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 )
 
 type EventMessage struct {
+	ID        string
 	EventType EventType
 	Data      []byte
+
+	GameMasterData []byte // sent to the Game Master instead of Data, e.g. with their controls added
 }
 
 type EventType string
@@ -19,98 +23,152 @@ type EventType string
 func (e EventType) String() string { return string(e) }
 
 const (
-	EventTypeRoll  EventType = "ROLL"
-	EventTypeStats EventType = "STATS"
+	EventTypeRoll        EventType = "ROLL"
+	EventTypeStats       EventType = "STATS"
+	EventTypeTransaction EventType = "TRANSACTION"
+	EventTypeGroupTask   EventType = "GROUP_TASK"
+	EventTypeOpposed     EventType = "OPPOSED"
+	EventTypeResync      EventType = "RESYNC" // the client missed too much and must reload the history and stats
 )
 
-func (s *Server) NotifyClients(eventType EventType) {
+// sseRetry is how long browsers wait before reconnecting, in milliseconds.
+const sseRetry = 2000
+
+// NotifyRoll sends just the new roll's history row to every client.
+func (r *Room) NotifyRoll(roll Roll) {
 	var buf bytes.Buffer
 
-	switch eventType {
-	case EventTypeRoll:
-		data := struct {
-			History Rolls
-			OOB     bool
-		}{
-			History: s.Rolls.Sort(),
-			OOB:     false,
-		}
+	// Render the new row HTML
+	if err := r.renderer.ExecuteSingle(&buf, "history_row", roll); err != nil {
+		log.Printf("Error rendering history row: %v", err)
+		return
+	}
 
-		// Render the new row HTML
-		if err := s.Renderer.ExecuteSingle(&buf, "history", data); err != nil {
-			log.Printf("Error rendering history: %v", err)
-			return
-		}
+	r.broadcast(EventMessage{
+		EventType: EventTypeRoll,
+		Data:      buf.Bytes(),
+	})
+}
+
+func (r *Room) NotifyClients(eventType EventType) {
+	var buf bytes.Buffer
 
+	switch eventType {
 	case EventTypeStats:
-		data := s.Stats
+		data := r.Stats
 
-		// Render the new row HTML
-		if err := s.Renderer.ExecuteSingle(&buf, "stats", data); err != nil {
+		if err := r.renderer.ExecuteSingle(&buf, "stats", data); err != nil {
 			log.Printf("Error rendering stats: %v", err)
 			return
 		}
 
 	default:
 		fmt.Fprintf(os.Stderr, "UNKNOWN EVENT TYPE: %s\n", eventType)
+		return
 	}
 
-	htmlData := struct {
-		HTML string `json:"html"`
-	}{
-		HTML: buf.String(),
-	}
+	r.broadcast(EventMessage{
+		EventType: eventType,
+		Data:      buf.Bytes(),
+	})
+}
 
-	data, err := json.Marshal(htmlData)
-	if err != nil {
-		panic(fmt.Errorf("failed to encode JSON: %w", err))
+func (r *Room) broadcast(message EventMessage) {
+	r.clientMutex.Lock()
+	defer r.clientMutex.Unlock()
+
+	message = r.events.add(message)
+
+	for client := range r.clients {
+		client.enqueue(message)
 	}
+}
 
-	message := EventMessage{
-		EventType: eventType,
-		Data:      data,
+// String formats the message for the event stream, splitting multi-line HTML across several data fields.
+func (e EventMessage) String() string {
+	var builder strings.Builder
+
+	if e.ID != "" {
+		fmt.Fprintf(&builder, "id: %s\n", e.ID)
 	}
 
-	s.clientMutex.RLock()
-	defer s.clientMutex.RUnlock()
+	fmt.Fprintf(&builder, "event: %s\n", e.EventType)
 
-	for clientChan := range s.clients {
-		select {
-		case clientChan <- message:
-		default:
-			// Client is not ready to receive the message, skip it
-		}
+	for _, line := range strings.Split(string(e.Data), "\n") {
+		fmt.Fprintf(&builder, "data: %s\n", line)
 	}
+
+	builder.WriteString("\n")
+
+	return builder.String()
 }
 
 func (s *Server) SSEHandler(writer http.ResponseWriter, req *http.Request) {
+	room := RoomFromContext(req)
+
 	// Set headers for SSE
 	writer.Header().Set("Content-Type", "text/event-stream")
 	writer.Header().Set("Cache-Control", "no-cache")
 	writer.Header().Set("Connection", "keep-alive")
 
-	// Create a channel for this client
-	messageChan := make(chan EventMessage)
+	client := newSSEClient(UserFromContext(req))
+
+	// Register the client and queue up what it missed in one go, so no event is sent twice or skipped
+	room.clientMutex.Lock()
+	room.clients[client] = true
+
+	if lastEventID := req.Header.Get("Last-Event-ID"); lastEventID != "" {
+		missed, replayable := room.events.since(lastEventID)
+		if !replayable {
+			missed = []EventMessage{{ID: room.events.lastID(), EventType: EventTypeResync, Data: []byte("resync")}}
+		}
+
+		for _, message := range missed {
+			client.enqueue(message)
+		}
+	}
+	room.clientMutex.Unlock()
 
-	// Register the client
-	s.clientMutex.Lock()
-	s.clients[messageChan] = true
-	s.clientMutex.Unlock()
+	sseMetrics.Add("clients", 1)
 
 	// Remove the client when the connection is closed
 	defer func() {
-		s.clientMutex.Lock()
-		delete(s.clients, messageChan)
-		s.clientMutex.Unlock()
+		room.clientMutex.Lock()
+		delete(room.clients, client)
+		room.clientMutex.Unlock()
+
+		sseMetrics.Add("clients", -1)
 	}()
 
+	controller := http.NewResponseController(writer)
+
+	fmt.Fprintf(writer, "retry: %d\n\n", sseRetry)
+
+	if err := controller.Flush(); err != nil {
+		return
+	}
+
 	for {
 		select {
-		case message := <-messageChan:
-			msg := fmt.Sprintf("event: %s\ndata: %s\n\n", message.EventType, message.Data)
-			fmt.Printf("MESSAGE: %s\n", msg)
-			fmt.Fprint(writer, msg)
-			writer.(http.Flusher).Flush()
+		case <-client.ready:
+			// a client that can't keep up is dropped rather than holding up the handler forever
+			if err := controller.SetWriteDeadline(time.Now().Add(clientWriteTimeout)); err != nil {
+				log.Printf("Failed to set SSE write deadline: %v", err)
+			}
+
+			for _, message := range client.take() {
+				if _, err := fmt.Fprint(writer, message.String()); err != nil {
+					return
+				}
+
+				sseMetrics.Add("events_sent", 1)
+			}
+
+			if err := controller.Flush(); err != nil {
+				return
+			}
+		case <-client.done:
+			return
 		case <-req.Context().Done():
 			return
 		}
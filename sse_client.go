@@ -0,0 +1,126 @@
+package main
+
+import (
+	"expvar"
+	"sync"
+	"time"
+)
+
+const (
+	// clientQueueSize is how many events a client may have waiting before it's disconnected. Reconnecting clients
+	// replay from the room's event log, so this must be smaller than eventLogSize.
+	clientQueueSize = 64
+	// clientSlowQueue is the queue length at which a client counts as slow.
+	clientSlowQueue = 16
+	// clientMaxLag is how long a client may stay slow before it's disconnected.
+	clientMaxLag = 10 * time.Second
+	// clientWriteTimeout bounds a single write to a client.
+	clientWriteTimeout = 10 * time.Second
+)
+
+var sseMetrics = expvar.NewMap("sse")
+
+// sseClient is one SSE connection's queue of events waiting to be written.
+type sseClient struct {
+	user *User
+
+	mutex     sync.Mutex
+	pending   []EventMessage
+	slowSince time.Time
+	closed    bool
+
+	ready chan struct{} // signalled when pending becomes non-empty
+	done  chan struct{} // closed when the client is disconnected for falling behind
+}
+
+func newSSEClient(user *User) *sseClient {
+	return &sseClient{
+		user:    user,
+		pending: make([]EventMessage, 0, clientQueueSize),
+		ready:   make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+}
+
+// enqueue adds message to the client's queue. A STATS event replaces any STATS event still waiting, since only the
+// latest stats matter. A client whose queue fills up, or that stays slow for longer than clientMaxLag, is disconnected
+// so it can reconnect and catch up from the event log.
+func (c *sseClient) enqueue(message EventMessage) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return
+	}
+
+	if message.EventType == EventTypeStats {
+		c.removePending(EventTypeStats)
+	}
+
+	if message.GameMasterData != nil && c.user.IsGameMaster {
+		message.Data = message.GameMasterData
+	}
+
+	if len(c.pending) >= clientQueueSize {
+		sseMetrics.Add("events_dropped", int64(len(c.pending))+1)
+		c.disconnect()
+
+		return
+	}
+
+	c.pending = append(c.pending, message)
+
+	if len(c.pending) >= clientSlowQueue {
+		if c.slowSince.IsZero() {
+			c.slowSince = time.Now()
+			sseMetrics.Add("clients_slow", 1)
+		} else if time.Since(c.slowSince) > clientMaxLag {
+			sseMetrics.Add("events_dropped", int64(len(c.pending)))
+			c.disconnect()
+
+			return
+		}
+	}
+
+	select {
+	case c.ready <- struct{}{}:
+	default:
+	}
+}
+
+// take empties the queue and returns everything that was in it.
+func (c *sseClient) take() []EventMessage {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	messages := c.pending
+	c.pending = make([]EventMessage, 0, clientQueueSize)
+	c.slowSince = time.Time{}
+
+	return messages
+}
+
+// removePending drops waiting events of the given type. The caller must hold the client's lock.
+func (c *sseClient) removePending(eventType EventType) {
+	kept := c.pending[:0]
+
+	for _, pending := range c.pending {
+		if pending.EventType == eventType {
+			sseMetrics.Add("events_coalesced", 1)
+			continue
+		}
+
+		kept = append(kept, pending)
+	}
+
+	c.pending = kept
+}
+
+// disconnect gives up on the client. The caller must hold the client's lock.
+func (c *sseClient) disconnect() {
+	c.closed = true
+	c.pending = nil
+	close(c.done)
+
+	sseMetrics.Add("clients_disconnected", 1)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSSEClientQueue(t *testing.T) {
+	event := func(eventType EventType, data string) EventMessage {
+		return EventMessage{EventType: eventType, Data: []byte(data)}
+	}
+
+	rolls := func(num int) []EventMessage {
+		events := make([]EventMessage, num)
+		for i := range events {
+			events[i] = event(EventTypeRoll, fmt.Sprint(i))
+		}
+
+		return events
+	}
+
+	data := func(events []EventMessage) []string {
+		var result []string
+		for _, message := range events {
+			result = append(result, string(message.Data))
+		}
+
+		return result
+	}
+
+	tests := []struct {
+		name         string
+		events       []EventMessage
+		want         []string // data of the events waiting, in order
+		disconnected bool
+	}{
+		{
+			name:   "events wait in order",
+			events: []EventMessage{event(EventTypeRoll, "1"), event(EventTypeTransaction, "2"), event(EventTypeRoll, "3")},
+			want:   []string{"1", "2", "3"},
+		},
+		{
+			name: "only the latest stats wait",
+			events: []EventMessage{
+				event(EventTypeStats, "first"),
+				event(EventTypeRoll, "1"),
+				event(EventTypeStats, "second"),
+				event(EventTypeRoll, "2"),
+				event(EventTypeStats, "latest"),
+			},
+			want: []string{"1", "2", "latest"},
+		},
+		{
+			name:   "a full queue stays queued",
+			events: rolls(clientQueueSize),
+			want:   data(rolls(clientQueueSize)),
+		},
+		{
+			name:         "overflowing the queue disconnects the client",
+			events:       rolls(clientQueueSize + 1),
+			disconnected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newSSEClient(&User{Name: "p"})
+
+			for _, message := range test.events {
+				client.enqueue(message)
+			}
+
+			select {
+			case <-client.done:
+				if !test.disconnected {
+					t.Fatalf("got disconnected, want events waiting")
+				}
+
+				return
+			default:
+				if test.disconnected {
+					t.Fatalf("got %d events waiting, want a disconnected client", len(client.pending))
+				}
+			}
+
+			select {
+			case <-client.ready:
+			default:
+				t.Fatalf("client wasn't told events are ready")
+			}
+
+			if got := data(client.take()); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v waiting, want %v", got, test.want)
+			}
+
+			if len(client.take()) != 0 {
+				t.Errorf("got events waiting after taking them all")
+			}
+		})
+	}
+}
+
+func TestSSEClientLag(t *testing.T) {
+	client := newSSEClient(&User{Name: "p"})
+
+	for i := range clientSlowQueue {
+		client.enqueue(EventMessage{EventType: EventTypeRoll, Data: []byte(fmt.Sprint(i))})
+	}
+
+	if client.slowSince.IsZero() {
+		t.Fatalf("client with %d events waiting isn't marked slow", clientSlowQueue)
+	}
+
+	// catching up forgives being slow
+	client.take()
+
+	if !client.slowSince.IsZero() {
+		t.Fatalf("client is still marked slow after catching up")
+	}
+
+	for i := range clientSlowQueue {
+		client.enqueue(EventMessage{EventType: EventTypeRoll, Data: []byte(fmt.Sprint(i))})
+	}
+
+	client.mutex.Lock()
+	client.slowSince = time.Now().Add(-clientMaxLag - time.Second)
+	client.mutex.Unlock()
+
+	client.enqueue(EventMessage{EventType: EventTypeRoll, Data: []byte("late")})
+
+	select {
+	case <-client.done:
+	default:
+		t.Fatalf("client slow for longer than %s wasn't disconnected", clientMaxLag)
+	}
+
+	// later events are ignored rather than panicking on the closed channel
+	client.enqueue(EventMessage{EventType: EventTypeRoll, Data: []byte("later")})
+
+	if len(client.take()) != 0 {
+		t.Errorf("got events waiting for a disconnected client")
+	}
+}
+
+// listen connects an SSE client for user to the room, as SSEHandler does.
+func listen(room *Room, user *User) *sseClient {
+	client := newSSEClient(user)
+
+	room.clientMutex.Lock()
+	room.clients[client] = true
+	room.clientMutex.Unlock()
+
+	return client
+}
+
+// received returns everything the client was sent, as it would be written to the stream.
+func received(client *sseClient) string {
+	var builder strings.Builder
+
+	for _, message := range client.take() {
+		builder.WriteString(message.String())
+	}
+
+	return builder.String()
+}
+
+func TestGameMasterData(t *testing.T) {
+	room := newTestRoom(t)
+	player := listen(room, testPlayer("p", "Kirk"))
+	gm := listen(room, &User{Name: "GM", IsGameMaster: true})
+
+	room.broadcast(EventMessage{EventType: EventTypeTransaction, Data: []byte("everyone"), GameMasterData: []byte("GM")})
+	room.broadcast(EventMessage{EventType: EventTypeRoll, Data: []byte("roll")})
+
+	if got := received(player); !strings.Contains(got, "data: everyone\n") || strings.Contains(got, "data: GM\n") {
+		t.Errorf("player got %q, want the players' version", got)
+	}
+
+	if got := received(gm); !strings.Contains(got, "data: GM\n") || strings.Contains(got, "data: everyone\n") {
+		t.Errorf("Game Master got %q, want their own version", got)
+	}
+
+	// reconnecting replays each version to the right client
+	late := newSSEClient(testPlayer("q", "Spock"))
+
+	room.clientMutex.Lock()
+	missed, _ := room.events.since(fmt.Sprintf("%d-0", room.events.epoch))
+	room.clientMutex.Unlock()
+
+	for _, message := range missed {
+		late.enqueue(message)
+	}
+
+	if got := received(late); strings.Contains(got, "data: GM\n") || !strings.Contains(got, "data: roll\n") {
+		t.Errorf("replayed %q to a player, want the players' version", got)
+	}
+}
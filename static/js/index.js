@@ -1,16 +1,85 @@
 document.addEventListener("DOMContentLoaded", function() {
+    document.body.addEventListener("htmx:beforeRequest", function(event) {
+        var errors = event.detail.elt.querySelectorAll(".form__error");
+        errors.forEach(function(error) {
+            error.innerHTML = "";
+        });
+        document.getElementById("errors").innerHTML = "";
+    });
+
+    document.body.addEventListener("htmx:beforeSwap", function(event) {
+        var xhr = event.detail.xhr;
+        if (xhr.status < 400 || !(xhr.getResponseHeader("Content-Type") || "").startsWith("text/html")) {
+            return;
+        }
+
+        // show the error next to the form that caused it, or at the top of the page
+        event.detail.shouldSwap = true;
+        event.detail.isError = false;
+        event.detail.target = event.detail.elt.querySelector(".form__error") || document.getElementById("errors");
+    });
+
+    // picking an attribute and discipline from the character sheet shows the target number and crit range they give
+    document.body.addEventListener("change", function(event) {
+        var picker = event.target.closest(".character-picker");
+        if (!picker) {
+            return;
+        }
+
+        var form = picker.closest("form");
+        var attribute = form.querySelector("[name=attribute]").selectedOptions[0];
+        var discipline = form.querySelector("[name=discipline]").selectedOptions[0];
+        var focus = form.querySelector("[name=focus]").value;
+        if (!attribute.value || !discipline.value) {
+            return;
+        }
+
+        var disciplineValue = Number(discipline.dataset.value);
+        var target = form.querySelector("[name=target]");
+        var critOn = form.querySelector("[name=crit-on]");
+
+        if (target) {
+            target.value = Number(attribute.dataset.value) + disciplineValue;
+        }
+        if (critOn) {
+            critOn.value = focus ? Math.max(disciplineValue, 1) : 1;
+        }
+    });
+
     document.body.addEventListener("htmx:sseMessage", function(event) {
         console.debug(event);
-        var data = JSON.parse(event.detail.data);
 
         switch (event.detail.type) {
-        case "ROLL":
-            var history_div = document.getElementById("history")
-            history_div.outerHTML = data.html;
-            break;
         case "STATS":
-            var stats_div = document.getElementById("stats")
-            stats_div.outerHTML = data.html;
+            // the Game Master's editors and the extended task lists aren't part of the stats panel, so they reload
+            // themselves
+            if (document.getElementById("traits-editor")) {
+                htmx.trigger("#traits-editor", "refresh");
+            }
+            if (document.getElementById("extended-tasks-editor")) {
+                htmx.trigger("#extended-tasks-editor", "refresh");
+            }
+            document.querySelectorAll(".extended-task-select").forEach(function(select) {
+                htmx.trigger(select, "refresh");
+            });
+            // the turn order is in the stats, but who can act depends on who's looking
+            htmx.trigger("#conflict", "refresh");
+            break;
+        case "GROUP_TASK":
+            // what the group task panel shows depends on who's looking, so everyone reloads their own
+            htmx.trigger("#group-task", "refresh");
+            break;
+        case "OPPOSED":
+            htmx.trigger("#opposed-task", "refresh");
+            break;
+        case "RESYNC":
+            // we missed too many events while disconnected, so reload everything
+            htmx.trigger("#history-container", "resync");
+            htmx.trigger("#stats-container", "resync");
+            htmx.trigger("#transactions-container", "resync");
+            htmx.trigger("#group-task-container", "resync");
+            htmx.trigger("#opposed-task-container", "resync");
+            htmx.trigger("#conflict-container", "resync");
             break;
         }
     });
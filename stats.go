@@ -1,10 +1,15 @@
 package main
 
 import (
+	"encoding/json"
+	"slices"
 	"strings"
 	"sync"
 )
 
+// MaxMomentum is the most Momentum the group pool can hold.
+const MaxMomentum = 6
+
 type SceneTraits []string
 
 func (s SceneTraits) AsString() string {
@@ -16,8 +21,24 @@ type Stats struct {
 	Threat          int                 `json:"threat"`
 	SceneTraits     SceneTraits         `json:"scene_traits"`
 	CharacterTraits map[string][]string `json:"character_traits"`
+	ExtendedTasks   []ExtendedTask      `json:"extended_tasks,omitempty"`
+
+	CharacterSheets map[string]*CharacterSheet `json:"character_sheets,omitempty"` // by character name
+	CurrentConflict *Conflict                  `json:"conflict,omitempty"`
+	NPCs            []NPC                      `json:"npcs,omitempty"` // only ever shown to the Game Master
 
-	Mutex sync.RWMutex
+	Mutex sync.RWMutex `json:"-"`
+}
+
+// statsJSON has the same fields as Stats but none of its methods, so it can be encoded without recursing.
+type statsJSON Stats
+
+// MarshalJSON encodes the stats while holding the read lock.
+func (s *Stats) MarshalJSON() ([]byte, error) {
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+
+	return json.Marshal((*statsJSON)(s))
 }
 
 func (s *Stats) SetMomentum(value int) {
@@ -26,6 +47,75 @@ func (s *Stats) SetMomentum(value int) {
 	s.Momentum = value
 }
 
+// AddMomentum changes the group pool by delta, keeping it between 0 and MaxMomentum. It returns the change actually
+// made.
+func (s *Stats) AddMomentum(delta int) int {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	before := s.Momentum
+	s.Momentum = min(max(s.Momentum+delta, 0), max(MaxMomentum, before))
+
+	return s.Momentum - before
+}
+
+// SpendMomentum takes amount from the group pool, failing if there isn't enough.
+func (s *Stats) SpendMomentum(amount int) error {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	if amount > s.Momentum {
+		return validationErr("There is only %d Momentum to spend.", s.Momentum)
+	}
+
+	s.Momentum -= amount
+
+	return nil
+}
+
+// SpendThreat takes amount from Threat, failing if there isn't enough.
+func (s *Stats) SpendThreat(amount int) error {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	if amount > s.Threat {
+		return validationErr("There is only %d Threat to spend.", s.Threat)
+	}
+
+	s.Threat -= amount
+
+	return nil
+}
+
+// SetPools sets Momentum and Threat, leaving either alone if it's nil. It returns the changes made.
+func (s *Stats) SetPools(momentum, threat *int) (int, int) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	momentumBefore, threatBefore := s.Momentum, s.Threat
+
+	if momentum != nil {
+		s.Momentum = *momentum
+	}
+
+	if threat != nil {
+		s.Threat = *threat
+	}
+
+	return s.Momentum - momentumBefore, s.Threat - threatBefore
+}
+
+// AddThreat changes Threat by delta without letting it go below 0. It returns the change actually made.
+func (s *Stats) AddThreat(delta int) int {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	before := s.Threat
+	s.Threat = max(s.Threat+delta, 0)
+
+	return s.Threat - before
+}
+
 func (s *Stats) SetThreat(value int) {
 	s.Mutex.Lock()
 	defer s.Mutex.Unlock()
@@ -47,3 +137,47 @@ func (s *Stats) SetCharacterTraits(character string, traits []string) {
 		delete(s.CharacterTraits, character)
 	}
 }
+
+// AddCharacterTrait gives character a trait, returning false if they already have it.
+func (s *Stats) AddCharacterTrait(character, trait string) bool {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	if slices.Contains(s.CharacterTraits[character], trait) {
+		return false
+	}
+
+	s.CharacterTraits[character] = append(s.CharacterTraits[character], trait)
+
+	return true
+}
+
+// RemoveCharacterTrait takes a trait away from character, returning false if they didn't have it.
+func (s *Stats) RemoveCharacterTrait(character, trait string) bool {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	traits := s.CharacterTraits[character]
+
+	index := slices.Index(traits, trait)
+	if index == -1 {
+		return false
+	}
+
+	traits = slices.Delete(slices.Clone(traits), index, index+1)
+	if len(traits) == 0 {
+		delete(s.CharacterTraits, character)
+	} else {
+		s.CharacterTraits[character] = traits
+	}
+
+	return true
+}
+
+// CharacterTraitsFor returns a copy of character's traits.
+func (s *Stats) CharacterTraitsFor(character string) []string {
+	s.Mutex.RLock()
+	defer s.Mutex.RUnlock()
+
+	return slices.Clone(s.CharacterTraits[character])
+}
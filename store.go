@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	StoreKindMemory = "memory"
+	StoreKindJSON   = "json"
+	StoreKindLog    = "log"
+)
+
+// SavedState is everything a Store keeps for a room.
+type SavedState struct {
+	Rolls        Rolls
+	Stats        *Stats // nil if none have been saved yet
+	Transactions []Transaction
+}
+
+// Store persists the roll history, stats and transactions so they survive server restarts.
+type Store interface {
+	Load() (*SavedState, error)
+	AppendRoll(roll Roll) error
+	SaveStats(stats *Stats) error
+	AppendTransaction(transaction Transaction) error
+	Close() error
+}
+
+// NewStore sets up a Store of the given kind in dataDir. An empty dataDir keeps everything in memory.
+func NewStore(kind, dataDir string) (Store, error) {
+	if dataDir == "" || kind == StoreKindMemory {
+		return &MemoryStore{}, nil
+	}
+
+	if err := os.MkdirAll(dataDir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create data directory %q: %w", dataDir, err)
+	}
+
+	switch kind {
+	case StoreKindJSON:
+		return NewJSONFileStore(dataDir)
+	case StoreKindLog:
+		return NewLogStore(dataDir)
+	}
+
+	return nil, fmt.Errorf("unknown store kind %q", kind)
+}
+
+// RoomDataDir returns where a room's data is kept. The default room uses dataDir itself so that data saved before rooms
+// existed is still found; every other room gets its own directory under dataDir/rooms.
+func RoomDataDir(dataDir, room string) string {
+	if dataDir == "" || room == defaultRoomName {
+		return dataDir
+	}
+
+	return filepath.Join(dataDir, "rooms", room)
+}
+
+// MemoryStore doesn't persist anything.
+type MemoryStore struct{}
+
+func (m *MemoryStore) Load() (*SavedState, error)          { return &SavedState{}, nil }
+func (m *MemoryStore) AppendRoll(Roll) error               { return nil }
+func (m *MemoryStore) SaveStats(*Stats) error              { return nil }
+func (m *MemoryStore) AppendTransaction(Transaction) error { return nil }
+func (m *MemoryStore) Close() error                        { return nil }
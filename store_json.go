@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// JSONFileStore keeps the rolls, stats and transactions in separate JSON files, rewriting each file in full on every
+// change.
+type JSONFileStore struct {
+	rollsPath        string
+	statsPath        string
+	transactionsPath string
+	rolls            Rolls
+	transactions     []Transaction
+	mutex            sync.Mutex
+}
+
+func NewJSONFileStore(dataDir string) (*JSONFileStore, error) {
+	store := &JSONFileStore{
+		rollsPath: filepath.Join(dataDir, "rolls.json"),
+		statsPath: filepath.Join(dataDir, "stats.json"),
+
+		transactionsPath: filepath.Join(dataDir, "transactions.json"),
+	}
+
+	return store, nil
+}
+
+func (j *JSONFileStore) Load() (*SavedState, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	state := &SavedState{}
+
+	if err := readJSONFile(j.rollsPath, &state.Rolls); err != nil {
+		return nil, fmt.Errorf("failed to load rolls: %w", err)
+	}
+
+	if err := readJSONFile(j.statsPath, &state.Stats); err != nil {
+		return nil, fmt.Errorf("failed to load stats: %w", err)
+	}
+
+	if err := readJSONFile(j.transactionsPath, &state.Transactions); err != nil {
+		return nil, fmt.Errorf("failed to load transactions: %w", err)
+	}
+
+	j.rolls = state.Rolls
+	j.transactions = state.Transactions
+
+	return state, nil
+}
+
+func (j *JSONFileStore) AppendRoll(roll Roll) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	rolls := append(j.rolls, roll)
+	if err := writeJSONFile(j.rollsPath, rolls); err != nil {
+		return fmt.Errorf("failed to save rolls: %w", err)
+	}
+
+	j.rolls = rolls
+
+	return nil
+}
+
+func (j *JSONFileStore) SaveStats(stats *Stats) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if err := writeJSONFile(j.statsPath, stats); err != nil {
+		return fmt.Errorf("failed to save stats: %w", err)
+	}
+
+	return nil
+}
+
+func (j *JSONFileStore) AppendTransaction(transaction Transaction) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	transactions := append(j.transactions, transaction)
+	if err := writeJSONFile(j.transactionsPath, transactions); err != nil {
+		return fmt.Errorf("failed to save transactions: %w", err)
+	}
+
+	j.transactions = transactions
+
+	return nil
+}
+
+func (j *JSONFileStore) Close() error { return nil }
+
+// readJSONFile decodes path into target, leaving target untouched if the file doesn't exist yet.
+func readJSONFile(path string, target any) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read %q: %w", path, err)
+	}
+
+	if err := json.Unmarshal(data, target); err != nil {
+		return fmt.Errorf("failed to parse %q: %w", path, err)
+	}
+
+	return nil
+}
+
+// writeJSONFile atomically replaces path with the JSON encoding of data.
+func writeJSONFile(path string, data any) error {
+	encoded, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode JSON: %w", err)
+	}
+
+	tmpPath := path + ".tmp"
+
+	if err := os.WriteFile(tmpPath, encoded, 0o600); err != nil {
+		return fmt.Errorf("failed to write %q: %w", tmpPath, err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace %q: %w", path, err)
+	}
+
+	return nil
+}
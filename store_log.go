@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+type logRecordType string
+
+const (
+	logRecordRoll        logRecordType = "roll"
+	logRecordStats       logRecordType = "stats"
+	logRecordTransaction logRecordType = "transaction"
+)
+
+type logRecord struct {
+	Type        logRecordType `json:"type"`
+	Roll        *Roll         `json:"roll,omitempty"`
+	Stats       *Stats        `json:"stats,omitempty"`
+	Transaction *Transaction  `json:"transaction,omitempty"`
+}
+
+// LogStore appends every change to a single JSON lines file and replays it on Load. The latest stats record wins.
+type LogStore struct {
+	file  *os.File
+	mutex sync.Mutex
+}
+
+func NewLogStore(dataDir string) (*LogStore, error) {
+	path := filepath.Join(dataDir, "d20.log")
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log %q: %w", path, err)
+	}
+
+	return &LogStore{file: file}, nil
+}
+
+func (l *LogStore) Load() (*SavedState, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if _, err := l.file.Seek(0, 0); err != nil {
+		return nil, fmt.Errorf("failed to rewind log: %w", err)
+	}
+
+	state := &SavedState{}
+	reader := bufio.NewReader(l.file)
+
+	var offset int64
+
+	for lineNum := 1; ; lineNum++ {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to read log: %w", err)
+		}
+
+		if len(line) == 0 {
+			break
+		}
+
+		record := logRecord{}
+
+		parseErr := json.Unmarshal(line, &record)
+		if parseErr == nil && !bytes.HasSuffix(line, []byte("\n")) {
+			parseErr = io.ErrUnexpectedEOF
+		}
+
+		if parseErr != nil {
+			// a crash in the middle of an append leaves a torn last record, which never made it into the room, so
+			// it's dropped; anything earlier is real corruption
+			if _, peekErr := reader.Peek(1); !errors.Is(peekErr, io.EOF) {
+				return nil, fmt.Errorf("corrupt log record on line %d: %w", lineNum, parseErr)
+			}
+
+			log.Printf("Dropping incomplete log record on line %d: %v", lineNum, parseErr)
+
+			if err := l.file.Truncate(offset); err != nil {
+				return nil, fmt.Errorf("failed to truncate incomplete log record: %w", err)
+			}
+
+			break
+		}
+
+		offset += int64(len(line))
+
+		switch record.Type {
+		case logRecordRoll:
+			if record.Roll != nil {
+				state.Rolls = append(state.Rolls, *record.Roll)
+			}
+		case logRecordStats:
+			state.Stats = record.Stats
+		case logRecordTransaction:
+			if record.Transaction != nil {
+				state.Transactions = append(state.Transactions, *record.Transaction)
+			}
+		default:
+			return nil, fmt.Errorf("unknown log record type %q on line %d", record.Type, lineNum)
+		}
+	}
+
+	return state, nil
+}
+
+func (l *LogStore) AppendRoll(roll Roll) error {
+	return l.append(logRecord{Type: logRecordRoll, Roll: &roll})
+}
+
+func (l *LogStore) SaveStats(stats *Stats) error {
+	return l.append(logRecord{Type: logRecordStats, Stats: stats})
+}
+
+func (l *LogStore) AppendTransaction(transaction Transaction) error {
+	return l.append(logRecord{Type: logRecordTransaction, Transaction: &transaction})
+}
+
+func (l *LogStore) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log: %w", err)
+	}
+
+	return nil
+}
+
+func (l *LogStore) append(record logRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s record: %w", record.Type, err)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to append %s record: %w", record.Type, err)
+	}
+
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync log: %w", err)
+	}
+
+	return nil
+}
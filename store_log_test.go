@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogStoreTornRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		tail    string // written after two good rolls
+		rolls   int
+		wantErr string
+	}{
+		{name: "clean", rolls: 2},
+		{name: "torn record", tail: `{"type":"roll","roll":{"id":3,`, rolls: 2},
+		{name: "record without its newline", tail: `{"type":"roll","roll":{"id":3}}`, rolls: 2},
+		{
+			name:    "corrupt record before the end",
+			tail:    "{\"type\":\"roll\",\n" + `{"type":"roll","roll":{"id":4}}` + "\n",
+			wantErr: "corrupt log record on line 3",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dataDir := t.TempDir()
+
+			store, err := NewLogStore(dataDir)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for id := 1; id <= 2; id++ {
+				if err := store.AppendRoll(Roll{ID: id}); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			if _, err := store.file.WriteString(test.tail); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			state, err := store.Load()
+
+			if test.wantErr != "" {
+				store.Close()
+
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want %q", err, test.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(state.Rolls) != test.rolls {
+				t.Fatalf("got %d rolls, want %d", len(state.Rolls), test.rolls)
+			}
+
+			// the torn record is cut off, so the next append starts on a line of its own
+			if err := store.AppendRoll(Roll{ID: 3}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := store.Close(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			data, err := os.ReadFile(filepath.Join(dataDir, "d20.log"))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if lines := strings.Count(string(data), "\n"); lines != 3 {
+				t.Errorf("got %d lines in the log, want 3:\n%s", lines, data)
+			}
+
+			store, err = NewLogStore(dataDir)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer store.Close()
+
+			state, err = store.Load()
+			if err != nil {
+				t.Fatalf("unexpected error reloading: %v", err)
+			}
+
+			if len(state.Rolls) != 3 || state.Rolls[2].ID != 3 {
+				t.Errorf("got %+v after reloading, want rolls 1 to 3", state.Rolls)
+			}
+		})
+	}
+}
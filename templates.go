@@ -7,6 +7,7 @@ import (
 	"io/fs"
 	"net/url"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -16,8 +17,11 @@ var funcMap = template.FuncMap{
 	"withQuery":         withQuery,
 	"withoutQuery":      withoutQuery,
 	"formatDiceResults": formatDiceResults,
+	"formatDie":         formatDie,
 	"formatList":        formatList,
+	"joinList":          joinList,
 	"formatMap":         formatMap,
+	"signed":            signed,
 }
 
 type TemplateRenderer struct {
@@ -105,6 +109,11 @@ func inStrings(search string, input []string) bool {
 	return false
 }
 
+// signed formats a change with its sign, e.g. "+2" or "-1".
+func signed(value int) string {
+	return fmt.Sprintf("%+d", value)
+}
+
 func withPath(path string, input *url.URL) *url.URL {
 	input.Path = path
 	return input
@@ -136,28 +145,72 @@ func withoutQuery(key string, input *url.URL) *url.URL {
 	return newURL
 }
 
-func formatDiceResults(results DiceResults) template.HTML {
-	htmlParts := make([]string, len(results))
+func formatDiceResults(roll Roll) template.HTML {
+	htmlParts := make([]string, 0, len(roll.Result)+len(roll.Challenge))
+
+	for _, result := range roll.Result {
+		htmlParts = append(htmlParts, formatDieResult(result))
+	}
 
-	for i, result := range results {
-		classes := []string{}
+	for _, result := range roll.Challenge {
+		htmlResult := formatChallengeResult(result)
 
-		switch {
-		case result.Complication:
-			classes = append(classes, "dice__result--complication")
-		case result.Crit:
-			classes = append(classes, "dice__result--crit")
-		default:
-			classes = append(classes, "dice__result--normal")
+		if result.Rerolled {
+			previous := formatChallengeResult(newChallengeDieResult(result.PreviousFace))
+			htmlResult = `<del class="dice__previous">` + previous + `</del>` + htmlResult
 		}
 
-		htmlResult := fmt.Sprintf(`<span class="dice__result %s">%d</span>`, strings.Join(classes, " "), result.Value)
-		htmlParts[i] = htmlResult
+		htmlParts = append(htmlParts, htmlResult)
 	}
 
 	return template.HTML(strings.Join(htmlParts, " ")) //nolint:gosec
 }
 
+// formatDie renders a single die, e.g. an assist.
+func formatDie(result DieResult) template.HTML {
+	return template.HTML(formatDieResult(result)) //nolint:gosec
+}
+
+func formatDieResult(result DieResult) string {
+	classes := []string{}
+
+	if result.Dropped {
+		classes = append(classes, "dice__result--dropped")
+	}
+
+	if result.Determination {
+		classes = append(classes, "dice__result--determination")
+	}
+
+	switch {
+	case result.Complication:
+		classes = append(classes, "dice__result--complication")
+	case result.Crit:
+		classes = append(classes, "dice__result--crit")
+	default:
+		classes = append(classes, "dice__result--normal")
+	}
+
+	htmlResult := fmt.Sprintf(`<span class="dice__result %s">%d</span>`, strings.Join(classes, " "), result.Value)
+
+	if result.Rerolled {
+		htmlResult = fmt.Sprintf(`<del class="dice__previous">%d</del>`, result.Previous) + htmlResult
+	}
+
+	return htmlResult
+}
+
+func formatChallengeResult(result ChallengeDieResult) string {
+	switch {
+	case result.Effect:
+		return fmt.Sprintf(`<span class="dice__result dice__result--effect">%d&#9733;</span>`, result.Value)
+	case result.Value == 0:
+		return `<span class="dice__result dice__result--blank">&ndash;</span>`
+	default:
+		return fmt.Sprintf(`<span class="dice__result dice__result--challenge">%d</span>`, result.Value)
+	}
+}
+
 func formatList(items []string) template.HTML {
 	htmlParts := []string{`<ul class="list">`}
 
@@ -170,10 +223,23 @@ func formatList(items []string) template.HTML {
 	return template.HTML(strings.Join(htmlParts, "\n")) //nolint:gosec
 }
 
+// joinList is the inverse of splitList, for filling in form fields.
+func joinList(items []string) string {
+	return strings.Join(items, ", ")
+}
+
 func formatMap(items map[string][]string) template.HTML {
 	htmlParts := []string{`<ul class="list">`}
 
-	for key, values := range items {
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		values := items[key]
 		keyString := template.HTMLEscapeString(key)
 		valuesString := template.HTMLEscapeString(strings.Join(values, ", "))
 		htmlParts = append(htmlParts, `<li class="list__item"><b>`+keyString+`:</b> `+valuesString+`</li>`)
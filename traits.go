@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// CharacterTraits is one character's row in the Game Master's traits editor.
+type CharacterTraits struct {
+	Character string
+	Traits    []string
+}
+
+// Characters returns the sorted names of every character that is connected or has traits, leaving out the Game
+// Master's.
+func (r *Room) Characters() []string {
+	seen := map[string]bool{}
+
+	r.clientMutex.RLock()
+	for client := range r.clients {
+		if client.user != nil && !client.user.IsGameMaster && client.user.CharacterName != "" {
+			seen[client.user.CharacterName] = true
+		}
+	}
+	r.clientMutex.RUnlock()
+
+	r.Stats.Mutex.RLock()
+	for character := range r.Stats.CharacterTraits {
+		seen[character] = true
+	}
+
+	for character := range r.Stats.CharacterSheets {
+		seen[character] = true
+	}
+	r.Stats.Mutex.RUnlock()
+
+	characters := make([]string, 0, len(seen))
+	for character := range seen {
+		characters = append(characters, character)
+	}
+
+	slices.Sort(characters)
+
+	return characters
+}
+
+func (s *Server) CharacterTraitsHandler(writer http.ResponseWriter, req *http.Request) {
+	s.renderTraitsEditor(writer, req)
+}
+
+func (s *Server) AddCharacterTraitHandler(writer http.ResponseWriter, req *http.Request) {
+	room := RoomFromContext(req)
+
+	character, trait, err := characterTraitFromForm(req)
+	if err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	if room.Stats.AddCharacterTrait(character, trait) {
+		if err := room.saveStats(); err != nil {
+			s.handleErr(writer, req, internalErr(err, "failed to save stats"))
+			return
+		}
+
+		room.NotifyClients(EventTypeStats)
+	}
+
+	s.renderTraitsEditor(writer, req)
+}
+
+func (s *Server) RemoveCharacterTraitHandler(writer http.ResponseWriter, req *http.Request) {
+	room := RoomFromContext(req)
+
+	character, trait, err := characterTraitFromForm(req)
+	if err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	if room.Stats.RemoveCharacterTrait(character, trait) {
+		if err := room.saveStats(); err != nil {
+			s.handleErr(writer, req, internalErr(err, "failed to save stats"))
+			return
+		}
+
+		room.NotifyClients(EventTypeStats)
+	}
+
+	s.renderTraitsEditor(writer, req)
+}
+
+func characterTraitFromForm(req *http.Request) (string, string, error) {
+	if err := req.ParseForm(); err != nil {
+		return "", "", validationErr("Failed to parse form: %v", err)
+	}
+
+	character := strings.TrimSpace(req.Form.Get("character"))
+	trait := strings.TrimSpace(req.Form.Get("trait"))
+
+	switch {
+	case character == "":
+		return "", "", validationErr("Choose a character.")
+	case trait == "":
+		return "", "", validationErr("Enter a trait.")
+	}
+
+	return character, trait, nil
+}
+
+func (s *Server) renderTraitsEditor(writer http.ResponseWriter, req *http.Request) {
+	room := RoomFromContext(req)
+
+	characters := room.Characters()
+	rows := make([]CharacterTraits, 0, len(characters))
+
+	for _, character := range characters {
+		rows = append(rows, CharacterTraits{
+			Character: character,
+			Traits:    room.Stats.CharacterTraitsFor(character),
+		})
+	}
+
+	data := struct {
+		Room       *Room
+		Characters []CharacterTraits
+	}{
+		Room:       room,
+		Characters: rows,
+	}
+
+	if err := s.Renderer.ExecuteSingle(writer, "traits_editor", data); err != nil {
+		s.handleErr(writer, req, internalErr(err, "failed to execute traits editor template"))
+		return
+	}
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxBoughtDice is how many extra d20s can be bought for a single roll.
+const MaxBoughtDice = 3
+
+type TransactionKind string
+
+const (
+	TransactionSpendMomentum TransactionKind = "spend_momentum"
+	TransactionBuyDice       TransactionKind = "buy_dice"
+	TransactionRollMomentum  TransactionKind = "roll_momentum" // Momentum generated by a roll
+	TransactionSet           TransactionKind = "set"           // the Game Master set the pools directly
+	TransactionRevert        TransactionKind = "revert"        // the Game Master vetoed a transaction
+	TransactionUndo          TransactionKind = "undo"
+	TransactionRedo          TransactionKind = "redo" // reverts an undo
+	TransactionInitiative    TransactionKind = "keep_initiative"
+	TransactionNPCDice       TransactionKind = "npc_dice"
+)
+
+// Transaction is an entry in the room's append-only ledger of changes to Momentum and Threat. Nothing is ever removed:
+// reverts, undos and redos are transactions of their own that cancel an earlier one.
+type Transaction struct {
+	ID       int             `json:"id"`
+	Kind     TransactionKind `json:"kind"`
+	User     *User           `json:"user"`
+	Reason   string          `json:"reason"`
+	Momentum int             `json:"momentum"` // change to the Momentum pool
+	Threat   int             `json:"threat"`   // change to Threat
+	Dice     int             `json:"dice,omitempty"`
+	RollID   int             `json:"roll_id,omitempty"` // the roll that bought dice or generated Momentum
+	Reverts  int             `json:"reverts,omitempty"` // the transaction a revert, undo or redo cancelled
+	Time     time.Time       `json:"time"`
+}
+
+// Cancels reports whether the transaction exists to cancel another.
+func (t Transaction) Cancels() bool {
+	return t.Kind == TransactionRevert || t.Kind == TransactionUndo || t.Kind == TransactionRedo
+}
+
+// BoughtDiceCost is the Threat added for buying num extra d20s: 1 for the first, 2 for the second and 3 for the third.
+func BoughtDiceCost(num int) int {
+	return num * (num + 1) / 2
+}
+
+// SpendMomentum takes amount from the group pool on user's behalf.
+func (r *Room) SpendMomentum(user *User, amount int, reason string) (Transaction, error) {
+	if amount < 1 || amount > MaxMomentum {
+		return Transaction{}, validationErr("Spend between 1 and %d Momentum.", MaxMomentum)
+	}
+
+	if err := r.Stats.SpendMomentum(amount); err != nil {
+		return Transaction{}, err
+	}
+
+	transaction := Transaction{
+		Kind:     TransactionSpendMomentum,
+		User:     user,
+		Reason:   reason,
+		Momentum: -amount,
+	}
+
+	return r.recordTransaction(transaction)
+}
+
+// BuyDice adds the cost of num extra d20s for rollID to Threat.
+func (r *Room) BuyDice(user *User, num, rollID int) (Transaction, error) {
+	threat := r.Stats.AddThreat(BoughtDiceCost(num))
+
+	transaction := Transaction{
+		Kind:   TransactionBuyDice,
+		User:   user,
+		Reason: fmt.Sprintf("Bought %d d20s", num),
+		Threat: threat,
+		Dice:   num,
+		RollID: rollID,
+	}
+
+	return r.recordTransaction(transaction)
+}
+
+// AddRollMomentum adds the Momentum generated by rollID to the group pool.
+func (r *Room) AddRollMomentum(user *User, momentum, rollID int) (Transaction, error) {
+	transaction := Transaction{
+		Kind:     TransactionRollMomentum,
+		User:     user,
+		Reason:   fmt.Sprintf("Momentum from roll #%d", rollID),
+		Momentum: r.Stats.AddMomentum(momentum),
+		RollID:   rollID,
+	}
+
+	return r.recordTransaction(transaction)
+}
+
+// SetPools sets Momentum and Threat for the Game Master, leaving either alone if it's nil. Nothing is recorded if
+// neither changed, in which case changed is false.
+func (r *Room) SetPools(user *User, momentum, threat *int) (transaction Transaction, changed bool, err error) {
+	momentumDelta, threatDelta := r.Stats.SetPools(momentum, threat)
+	if momentumDelta == 0 && threatDelta == 0 {
+		return Transaction{}, false, nil
+	}
+
+	transaction = Transaction{
+		Kind:     TransactionSet,
+		User:     user,
+		Reason:   "Set by the Game Master",
+		Momentum: momentumDelta,
+		Threat:   threatDelta,
+	}
+
+	transaction, err = r.recordTransaction(transaction)
+
+	return transaction, true, err
+}
+
+// RevertTransaction cancels transaction id on the Game Master's say-so.
+func (r *Room) RevertTransaction(user *User, id int) (Transaction, error) {
+	r.transactionMutex.Lock()
+
+	original, ok := r.transaction(id)
+
+	switch {
+	case !ok:
+		r.transactionMutex.Unlock()
+		return Transaction{}, notFoundErr("There is no transaction #%d.", id)
+	case original.Cancels():
+		r.transactionMutex.Unlock()
+		return Transaction{}, validationErr("Reverts, undos and redos can't be reverted.")
+	case r.cancelled()[id]:
+		r.transactionMutex.Unlock()
+		return Transaction{}, validationErr("Transaction #%d was already reverted.", id)
+	}
+
+	transaction, err := r.cancelTransaction(user, TransactionRevert, original, fmt.Sprintf("Reverted #%d", id))
+	r.transactionMutex.Unlock()
+
+	if err != nil {
+		return transaction, err
+	}
+
+	return transaction, r.publishTransaction(transaction)
+}
+
+// Undo cancels the last count changes that haven't been undone yet, newest first.
+func (r *Room) Undo(user *User, count int) ([]Transaction, error) {
+	return r.undoOrRedo(user, count, TransactionUndo)
+}
+
+// Redo re-applies the last count undone changes, as long as nothing else has changed since they were undone.
+func (r *Room) Redo(user *User, count int) ([]Transaction, error) {
+	return r.undoOrRedo(user, count, TransactionRedo)
+}
+
+func (r *Room) undoOrRedo(user *User, count int, kind TransactionKind) ([]Transaction, error) {
+	if count < 1 {
+		return nil, validationErr("Choose how many changes to %s.", kind)
+	}
+
+	r.transactionMutex.Lock()
+
+	var (
+		transactions []Transaction
+		err          error
+	)
+
+	for range count {
+		done, undone := r.undoStacks()
+
+		stack := done
+		if kind == TransactionRedo {
+			stack = undone
+		}
+
+		if len(stack) == 0 {
+			break
+		}
+
+		// redoing cancels the undo, which puts the original change back
+		target := stack[len(stack)-1]
+
+		reason := fmt.Sprintf("Undid #%d", target.ID)
+		if kind == TransactionRedo {
+			reason = fmt.Sprintf("Redid #%d", target.Reverts)
+		}
+
+		var transaction Transaction
+
+		transaction, err = r.cancelTransaction(user, kind, target, reason)
+		if err != nil {
+			break
+		}
+
+		transactions = append(transactions, transaction)
+	}
+
+	r.transactionMutex.Unlock()
+
+	if len(transactions) == 0 && err == nil {
+		return nil, validationErr("There is nothing to %s.", kind)
+	}
+
+	for _, transaction := range transactions {
+		if publishErr := r.publishTransaction(transaction); publishErr != nil && err == nil {
+			err = publishErr
+		}
+	}
+
+	return transactions, err
+}
+
+// cancelTransaction applies the opposite of original and appends the transaction that did it. Pools are kept within
+// their limits, so the new transaction records what actually changed. The caller must hold transactionMutex.
+func (r *Room) cancelTransaction(user *User, kind TransactionKind, original Transaction, reason string) (Transaction, error) {
+	transaction := Transaction{
+		Kind:     kind,
+		User:     user,
+		Reason:   reason,
+		Momentum: r.Stats.AddMomentum(-original.Momentum),
+		Threat:   r.Stats.AddThreat(-original.Threat),
+		RollID:   original.RollID,
+		Reverts:  original.ID,
+	}
+
+	err := r.appendTransaction(&transaction)
+
+	return transaction, err
+}
+
+// undoStacks replays the ledger to find what can be undone and redone: done holds the changes in the order they were
+// made and undone holds the undos waiting to be redone. Any new change clears undone. The caller must hold
+// transactionMutex.
+func (r *Room) undoStacks() (done, undone []Transaction) {
+	for _, transaction := range r.Transactions {
+		switch transaction.Kind {
+		case TransactionUndo:
+			if len(done) > 0 {
+				done = done[:len(done)-1]
+			}
+
+			undone = append(undone, transaction)
+		case TransactionRedo:
+			if len(undone) > 0 {
+				undone = undone[:len(undone)-1]
+			}
+
+			done = append(done, transaction)
+		default:
+			done = append(done, transaction)
+			undone = nil
+		}
+	}
+
+	return done, undone
+}
+
+// cancelled returns the IDs of the transactions that have been cancelled by a revert, undo or redo that is itself
+// still in effect. The caller must hold transactionMutex.
+func (r *Room) cancelled() map[int]bool {
+	cancelled := map[int]bool{}
+
+	// a transaction can only be cancelled by a later one, so by the time we reach it we know whether it stands
+	for i := len(r.Transactions) - 1; i >= 0; i-- {
+		transaction := r.Transactions[i]
+		if transaction.Cancels() && !cancelled[transaction.ID] {
+			cancelled[transaction.Reverts] = true
+		}
+	}
+
+	return cancelled
+}
+
+// recordTransaction saves a transaction whose changes have already been made to the stats and tells every client.
+func (r *Room) recordTransaction(transaction Transaction) (Transaction, error) {
+	r.transactionMutex.Lock()
+	err := r.appendTransaction(&transaction)
+	r.transactionMutex.Unlock()
+
+	if err != nil {
+		return transaction, err
+	}
+
+	return transaction, r.publishTransaction(transaction)
+}
+
+// appendTransaction assigns the transaction its ID and saves it. The caller must hold transactionMutex.
+func (r *Room) appendTransaction(transaction *Transaction) error {
+	transaction.ID = len(r.Transactions) + 1
+	transaction.Time = time.Now()
+
+	if err := r.store.AppendTransaction(*transaction); err != nil {
+		return fmt.Errorf("failed to save transaction: %w", err)
+	}
+
+	r.Transactions = append(r.Transactions, *transaction)
+
+	return nil
+}
+
+// publishTransaction saves the stats the transaction changed and tells every client about both.
+func (r *Room) publishTransaction(transaction Transaction) error {
+	if err := r.saveStats(); err != nil {
+		return err
+	}
+
+	r.NotifyTransaction(transaction)
+	r.NotifyClients(EventTypeStats)
+
+	return nil
+}
+
+// transaction finds transaction id. The caller must hold transactionMutex.
+func (r *Room) transaction(id int) (Transaction, bool) {
+	if id < 1 || id > len(r.Transactions) {
+		return Transaction{}, false
+	}
+
+	return r.Transactions[id-1], true
+}
+
+// TransactionView is a transaction as shown in the log.
+type TransactionView struct {
+	Transaction
+	Reverted   bool
+	RevertURL  string
+	GameMaster bool // show the Game Master's controls
+	OOB        bool // replace the existing entry instead of adding a new one
+}
+
+func (r *Room) transactionView(transaction Transaction, reverted bool) TransactionView {
+	return TransactionView{
+		Transaction: transaction,
+		Reverted:    reverted,
+		RevertURL:   r.URL(fmt.Sprintf("transactions/%d/revert", transaction.ID)),
+	}
+}
+
+// TransactionLog returns the transactions newest first, marking the ones that were reverted. Only the Game Master's
+// log can revert them.
+func (r *Room) TransactionLog(gameMaster bool) []TransactionView {
+	r.transactionMutex.Lock()
+	defer r.transactionMutex.Unlock()
+
+	views := make([]TransactionView, 0, len(r.Transactions))
+	cancelled := r.cancelled()
+
+	for i := len(r.Transactions) - 1; i >= 0; i-- {
+		view := r.transactionView(r.Transactions[i], cancelled[r.Transactions[i].ID])
+		view.GameMaster = gameMaster
+		views = append(views, view)
+	}
+
+	return views
+}
+
+// NotifyTransaction sends a new transaction to every client, along with the entry it cancelled or put back. The Game
+// Master gets a version they can revert.
+func (r *Room) NotifyTransaction(transaction Transaction) {
+	views := []TransactionView{r.transactionView(transaction, false)}
+
+	// update every entry whose standing changed: the one cancelled and, for a redo, the change it put back
+	r.transactionMutex.Lock()
+
+	cancelled := r.cancelled()
+
+	for current := transaction; current.Cancels(); {
+		previous, ok := r.transaction(current.Reverts)
+		if !ok {
+			break
+		}
+
+		view := r.transactionView(previous, cancelled[previous.ID])
+		view.OOB = true
+		views = append(views, view)
+		current = previous
+	}
+
+	r.transactionMutex.Unlock()
+
+	data, err := r.renderTransactions(views, false)
+	if err != nil {
+		log.Printf("Error rendering transaction: %v", err)
+		return
+	}
+
+	gameMasterData, err := r.renderTransactions(views, true)
+	if err != nil {
+		log.Printf("Error rendering transaction: %v", err)
+		return
+	}
+
+	r.broadcast(EventMessage{
+		EventType:      EventTypeTransaction,
+		Data:           data,
+		GameMasterData: gameMasterData,
+	})
+}
+
+// renderTransactions renders the entries for an SSE event, for the Game Master or for players.
+func (r *Room) renderTransactions(views []TransactionView, gameMaster bool) ([]byte, error) {
+	var buf bytes.Buffer
+
+	for _, view := range views {
+		view.GameMaster = gameMaster
+
+		if err := r.renderer.ExecuteSingle(&buf, "transaction", view); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// TransactionsHandler shows the ledger. Everybody can see it, like the Momentum and Threat it explains; only the Game
+// Master can revert, undo or redo.
+func (s *Server) TransactionsHandler(writer http.ResponseWriter, req *http.Request) {
+	user := UserFromContext(req)
+	room := RoomFromContext(req)
+
+	if err := s.Renderer.ExecuteSingle(writer, "transactions", room.TransactionLog(user.IsGameMaster)); err != nil {
+		s.handleErr(writer, req, internalErr(err, "failed to execute transactions template"))
+		return
+	}
+}
+
+func (s *Server) SpendMomentumHandler(writer http.ResponseWriter, req *http.Request) {
+	user := UserFromContext(req)
+	room := RoomFromContext(req)
+
+	if err := req.ParseForm(); err != nil {
+		s.handleErr(writer, req, validationErr("Failed to parse form: %v", err))
+		return
+	}
+
+	amount, err := strconv.Atoi(req.Form.Get("amount"))
+	if err != nil {
+		s.handleErr(writer, req, validationErr("Momentum must be a whole number."))
+		return
+	}
+
+	reason := strings.TrimSpace(req.Form.Get("reason"))
+	if reason == "" {
+		s.handleErr(writer, req, validationErr("Say what the Momentum is for."))
+		return
+	}
+
+	if _, err := room.SpendMomentum(user, amount, reason); err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) RevertTransactionHandler(writer http.ResponseWriter, req *http.Request) {
+	user := UserFromContext(req)
+	room := RoomFromContext(req)
+
+	id, err := strconv.Atoi(req.PathValue("id"))
+	if err != nil {
+		s.handleErr(writer, req, notFoundErr("There is no transaction %q.", req.PathValue("id")))
+		return
+	}
+
+	if _, err := room.RevertTransaction(user, id); err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) UndoHandler(writer http.ResponseWriter, req *http.Request) {
+	s.undoOrRedo(writer, req, TransactionUndo)
+}
+
+func (s *Server) RedoHandler(writer http.ResponseWriter, req *http.Request) {
+	s.undoOrRedo(writer, req, TransactionRedo)
+}
+
+func (s *Server) undoOrRedo(writer http.ResponseWriter, req *http.Request, kind TransactionKind) {
+	user := UserFromContext(req)
+	room := RoomFromContext(req)
+
+	if err := req.ParseForm(); err != nil {
+		s.handleErr(writer, req, validationErr("Failed to parse form: %v", err))
+		return
+	}
+
+	count := 1
+
+	if rawCount := req.Form.Get("count"); rawCount != "" {
+		parsed, err := strconv.Atoi(rawCount)
+		if err != nil {
+			s.handleErr(writer, req, validationErr("The number of changes to %s must be a whole number.", kind))
+			return
+		}
+
+		count = parsed
+	}
+
+	var err error
+	if kind == TransactionUndo {
+		_, err = room.Undo(user, count)
+	} else {
+		_, err = room.Redo(user, count)
+	}
+
+	if err != nil {
+		s.handleErr(writer, req, err)
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
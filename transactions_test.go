@@ -0,0 +1,236 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func newTestRoom(t *testing.T) *Room {
+	t.Helper()
+
+	renderer, err := NewTemplateRenderer()
+	if err != nil {
+		t.Fatalf("failed to set up templates: %v", err)
+	}
+
+	room, err := NewRoom(&RoomConfig{Name: "test"}, &MemoryStore{}, renderer)
+	if err != nil {
+		t.Fatalf("failed to set up room: %v", err)
+	}
+
+	return room
+}
+
+func TestLedger(t *testing.T) {
+	gm := &User{Name: "GM", IsGameMaster: true}
+	player := &User{Name: "p", CharacterName: "Kirk"}
+
+	setPools := func(momentum, threat int) func(*Room) error {
+		return func(r *Room) error {
+			_, _, err := r.SetPools(gm, &momentum, &threat)
+			return err
+		}
+	}
+
+	buyDice := func(num int) func(*Room) error {
+		return func(r *Room) error {
+			_, err := r.BuyDice(player, num, 1)
+			return err
+		}
+	}
+
+	spend := func(amount int) func(*Room) error {
+		return func(r *Room) error {
+			_, err := r.SpendMomentum(player, amount, "Create advantage")
+			return err
+		}
+	}
+
+	rollMomentum := func(amount int) func(*Room) error {
+		return func(r *Room) error {
+			_, err := r.AddRollMomentum(player, amount, 1)
+			return err
+		}
+	}
+
+	undo := func(count int) func(*Room) error {
+		return func(r *Room) error {
+			_, err := r.Undo(gm, count)
+			return err
+		}
+	}
+
+	redo := func(count int) func(*Room) error {
+		return func(r *Room) error {
+			_, err := r.Redo(gm, count)
+			return err
+		}
+	}
+
+	revert := func(id int) func(*Room) error {
+		return func(r *Room) error {
+			_, err := r.RevertTransaction(gm, id)
+			return err
+		}
+	}
+
+	type step struct {
+		do        func(*Room) error
+		wantErr   string
+		momentum  int
+		threat    int
+		cancelled []int // transactions cancelled once the step is done
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "undo and redo",
+			steps: []step{
+				{do: setPools(3, 2), momentum: 3, threat: 2},
+				{do: buyDice(2), momentum: 3, threat: 5},
+				{do: undo(1), momentum: 3, threat: 2, cancelled: []int{2}},
+				{do: undo(1), momentum: 0, threat: 0, cancelled: []int{1, 2}},
+				{do: undo(1), wantErr: "There is nothing to undo.", cancelled: []int{1, 2}},
+				{do: redo(1), momentum: 3, threat: 2, cancelled: []int{2, 4}},
+				{do: redo(1), momentum: 3, threat: 5, cancelled: []int{3, 4}},
+				{do: redo(1), wantErr: "There is nothing to redo.", momentum: 3, threat: 5, cancelled: []int{3, 4}},
+			},
+		},
+		{
+			name: "undo several at once",
+			steps: []step{
+				{do: rollMomentum(2), momentum: 2},
+				{do: rollMomentum(1), momentum: 3},
+				{do: buyDice(1), momentum: 3, threat: 1},
+				{do: undo(2), momentum: 2, threat: 0, cancelled: []int{2, 3}},
+				{do: redo(5), momentum: 3, threat: 1, cancelled: []int{4, 5}},
+			},
+		},
+		{
+			name: "a new change clears redo",
+			steps: []step{
+				{do: rollMomentum(3), momentum: 3},
+				{do: undo(1), momentum: 0, cancelled: []int{1}},
+				{do: rollMomentum(2), momentum: 2, cancelled: []int{1}},
+				{do: redo(1), wantErr: "There is nothing to redo.", momentum: 2, cancelled: []int{1}},
+				{do: undo(1), momentum: 0, cancelled: []int{1, 3}},
+			},
+		},
+		{
+			name: "revert",
+			steps: []step{
+				{do: setPools(4, 1), momentum: 4, threat: 1},
+				{do: spend(3), momentum: 1, threat: 1},
+				{do: revert(2), momentum: 4, threat: 1, cancelled: []int{2}},
+				{do: revert(2), wantErr: "Transaction #2 was already reverted.", momentum: 4, threat: 1, cancelled: []int{2}},
+				{
+					do: revert(3), wantErr: "Reverts, undos and redos can't be reverted.", momentum: 4, threat: 1,
+					cancelled: []int{2},
+				},
+				{do: revert(9), wantErr: "There is no transaction #9.", momentum: 4, threat: 1, cancelled: []int{2}},
+			},
+		},
+		{
+			name: "undoing a revert puts the change back",
+			steps: []step{
+				{do: buyDice(3), threat: 6},
+				{do: revert(1), threat: 0, cancelled: []int{1}},
+				{do: undo(1), threat: 6, cancelled: []int{2}},
+			},
+		},
+		{
+			name: "pools stay in bounds",
+			steps: []step{
+				{do: rollMomentum(5), momentum: 5},
+				{do: spend(4), momentum: 1},
+				{do: rollMomentum(5), momentum: MaxMomentum},
+				{do: spend(7), wantErr: "Spend between 1 and 6 Momentum.", momentum: MaxMomentum},
+				{do: buyDice(1), momentum: MaxMomentum, threat: 1},
+				{do: setPools(MaxMomentum, 0), momentum: MaxMomentum},
+				// the dice were bought with Threat that's gone, so there's nothing to take back
+				{do: revert(4), momentum: MaxMomentum, cancelled: []int{4}},
+				{do: undo(1), momentum: MaxMomentum, cancelled: []int{6}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			room := newTestRoom(t)
+
+			for i, step := range test.steps {
+				if mismatch := errMismatch(step.do(room), step.wantErr); mismatch != "" {
+					t.Fatalf("step %d: %s", i, mismatch)
+				}
+
+				if room.Stats.Momentum != step.momentum || room.Stats.Threat != step.threat {
+					t.Errorf("step %d: got Momentum %d and Threat %d, want %d and %d", i, room.Stats.Momentum,
+						room.Stats.Threat, step.momentum, step.threat)
+				}
+
+				var cancelled []int
+				for id := range room.cancelled() {
+					cancelled = append(cancelled, id)
+				}
+
+				slices.Sort(cancelled)
+
+				if !reflect.DeepEqual(cancelled, step.cancelled) {
+					t.Errorf("step %d: got %v cancelled, want %v", i, cancelled, step.cancelled)
+				}
+			}
+		})
+	}
+}
+
+func TestRevertOnlyForGameMaster(t *testing.T) {
+	server := newTestServer(t)
+	room := server.Rooms[defaultRoomName]
+	gm := testGameMaster(server)
+	kirk := testPlayer("p", "Kirk")
+
+	playerClient := listen(room, kirk)
+	gmClient := listen(room, gm)
+
+	if _, err := room.BuyDice(kirk, 2, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := received(playerClient)
+	if !strings.Contains(got, "Bought 2 d20s") || strings.Contains(got, "transaction__revert") {
+		t.Errorf("player was sent %q, want the transaction without a Revert button", got)
+	}
+
+	if got := received(gmClient); !strings.Contains(got, "transaction__revert") {
+		t.Errorf("Game Master was sent %q, want the transaction with a Revert button", got)
+	}
+
+	tests := []struct {
+		name   string
+		user   *User
+		revert bool
+	}{
+		{"player", kirk, false},
+		{"Game Master", gm, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := serve(t, server, test.user, "GET", room.URL("transactions"), nil)
+			wantStatus(t, recorder, http.StatusOK)
+
+			if got := strings.Contains(recorder.Body.String(), "transaction__revert"); got != test.revert {
+				t.Errorf("got a Revert button %t, want %t", got, test.revert)
+			}
+		})
+	}
+
+	recorder := serve(t, server, kirk, "POST", room.URL("transactions/1/revert"), "")
+	wantStatus(t, recorder, http.StatusForbidden)
+}
@@ -26,10 +26,21 @@ var (
 )
 
 type User struct {
-	Name          string `json:"name"`
-	CharacterName string `json:"character_name"`
-	IsGameMaster  bool   `json:"is_game_master"`
-	IPAddress     string `json:"ip_address"`
+	Name            string `json:"name"`
+	CharacterName   string `json:"character_name"`
+	Room            string `json:"room"`
+	IsGameMaster    bool   `json:"is_game_master"`
+	GameMasterClaim string `json:"game_master_claim,omitempty"` // see Server.isGameMaster
+	IPAddress       string `json:"ip_address"`
+}
+
+// Same reports whether u and other are the same player playing the same character.
+func (u *User) Same(other *User) bool {
+	if u == nil || other == nil {
+		return false
+	}
+
+	return u.Name == other.Name && u.CharacterName == other.CharacterName && u.IsGameMaster == other.IsGameMaster
 }
 
 func (u *User) CookieValue(secret []byte) (string, error) {
@@ -91,7 +102,29 @@ func (u *User) DataCookie(secret []byte) (*http.Cookie, error) {
 	return cookie, nil
 }
 
-func UserFromCookie(value string, secret []byte) (*User, error) {
+// UserFromCookie decrypts the user from a cookie value with any of the keyring's keys. The returned bool is true if the
+// cookie was encrypted with the previous key and should be re-issued with the current one.
+func UserFromCookie(value string, keys *Keyring) (*User, bool, error) {
+	var lastErr error
+
+	for i, secret := range keys.Keys() {
+		user, err := userFromCookie(value, secret)
+		if err == nil {
+			return user, i > 0, nil
+		}
+
+		// only a failure to decrypt is worth retrying with another key
+		if !errors.Is(err, ErrCrypto) {
+			return nil, false, err
+		}
+
+		lastErr = err
+	}
+
+	return nil, false, lastErr
+}
+
+func userFromCookie(value string, secret []byte) (*User, error) {
 	cookieParts := strings.Split(value, "||")
 	if len(cookieParts) != 3 {
 		return nil, fmt.Errorf("%w: expecting 3 parts", ErrInvalidFormat)